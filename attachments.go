@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// attachmentDir is the directory (relative to the static dir) that attachments of non-bundled pages are stored in.
+var attachmentDir = "attachments"
+
+// attachmentTypes maps the extensions that can be attached to the content types they're allowed to sniff as.
+// Office formats are zip (or legacy OLE) containers, which sniff as generic binaries.
+var attachmentTypes = map[string][]string{
+	".pdf":    {"application/pdf"},
+	".csv":    {"text/plain"},
+	".txt":    {"text/plain"},
+	".xlsx":   {"application/zip"},
+	".docx":   {"application/zip"},
+	".pptx":   {"application/zip"},
+	".ods":    {"application/zip"},
+	".odt":    {"application/zip"},
+	".odp":    {"application/zip"},
+	".vsdx":   {"application/zip"},
+	".xls":    {"application/octet-stream"},
+	".doc":    {"application/octet-stream"},
+	".ppt":    {"application/octet-stream"},
+	".drawio": {"text/plain", "text/xml"}, // xml is checked for markup browsers run scripts in
+	".zip":    {"application/zip"},
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scriptNamespaces are the namespaces of XML elements that browsers render as documents that can run scripts.
+var scriptNamespaces = []string{"http://www.w3.org/1999/xhtml", "http://www.w3.org/2000/svg"}
+
+// parseAttachmentTypes parses a comma separated list of extensions that can be attached, each optionally followed
+// by the content types it may sniff as e.g. ".pdf,.csv,.dwg=application/octet-stream|application/acad".
+func parseAttachmentTypes(value string) (map[string][]string, error) {
+	types := map[string][]string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		ext, contentTypes, ok := strings.Cut(item, "=")
+		ext = "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+		if !ok {
+			known, found := attachmentTypes[ext]
+			if !found {
+				return nil, fmt.Errorf("no default content types are known for %q, configure them like %s=application/octet-stream", ext, ext)
+			}
+			types[ext] = known
+			continue
+		}
+		types[ext] = strings.Split(contentTypes, "|")
+	}
+	return types, nil
+}
+
+// serveRepoFile serves a file stored in the repo e.g. an image or attachment. Uploaded files are untrusted, so
+// browsers mustn't guess their type or run any scripts in them as the editor.
+func serveRepoFile(w http.ResponseWriter, r *http.Request, file string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeFile(w, r, file)
+}
+
+// attachmentExtensions returns the sorted extensions that can be attached.
+func attachmentExtensions() []string {
+	var exts []string
+	for ext := range attachmentTypes {
+		exts = append(exts, ext)
+	}
+	slices.Sort(exts)
+	return exts
+}
+
+// storeAttachment stores a file attached to the given page, returning the URL the page should link to.
+// Like images, the file isn't committed until it's referenced by a page update.
+func storeAttachment(page, name string, buf []byte) (string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	pageFile, found := resolvePage(page)
+	if !found {
+		return "", fmt.Errorf("page %q does not exist", page)
+	}
+
+	if int64(len(buf)) > maxFileSize {
+		return "", rejectf("%s is %s, larger than the %s limit", name, formatSize(int64(len(buf))), formatSize(maxFileSize))
+	}
+
+	name = sanitizeFileName(name)
+	ext := strings.ToLower(filepath.Ext(name))
+	allowed, ok := attachmentTypes[ext]
+	if !ok {
+		return "", rejectf("%q files can't be attached, the allowed types are %s", ext, strings.Join(attachmentExtensions(), ", "))
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(buf))
+	if !slices.Contains(allowed, contentType) {
+		return "", rejectf("%s doesn't look like a %s file (detected %s)", name, ext, contentType)
+	}
+	if contentType == "text/xml" {
+		if err := checkXML(buf); err != nil {
+			return "", rejectf("%s can't be attached: %s", name, err)
+		}
+	}
+
+	dir := filepath.Join(site.StaticDir, attachmentDir)
+	prefix := site.basePath() + "/" + filepath.ToSlash(attachmentDir) + "/"
+	if isBundle(pageFile) {
+		dir = filepath.Dir(pageFile)
+		prefix = ""
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating dir: %w", err)
+	}
+
+	// Keep the original name, unless a different file already has it
+	file := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		existing, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading existing file: %w", err)
+		}
+		if bytes.Equal(existing, buf) {
+			return prefix + filepath.Base(file), nil
+		}
+		file = filepath.Join(dir, strings.TrimSuffix(name, ext)+"-"+strconv.Itoa(i)+ext)
+	}
+
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		return "", fmt.Errorf("writing attachment: %w", err)
+	}
+	return prefix + filepath.Base(file), nil
+}
+
+// sanitizeFileName makes an uploaded file's name safe to use in paths and markdown links.
+func sanitizeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	ext := filepath.Ext(name)
+	base := strings.Trim(unsafeNameChars.ReplaceAllString(strings.TrimSuffix(name, ext), "-"), "-.")
+	if base == "" {
+		base = "attachment"
+	}
+	return base + strings.ToLower(unsafeNameChars.ReplaceAllString(ext, ""))
+}
+
+// checkXML returns an error if an XML document is invalid or contains markup that browsers run scripts in when it's
+// opened: html, svg or script elements and stylesheets.
+func checkXML(buf []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid xml: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if slices.Contains(scriptNamespaces, token.Name.Space) || strings.EqualFold(token.Name.Local, "html") ||
+				strings.EqualFold(token.Name.Local, "script") {
+				return fmt.Errorf("it contains a %s element", token.Name.Local)
+			}
+		case xml.ProcInst:
+			if token.Target == "xml-stylesheet" {
+				return errors.New("it contains a stylesheet")
+			}
+		}
+	}
+}
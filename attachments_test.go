@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAttachmentTypes(t *testing.T) {
+	types, err := parseAttachmentTypes("pdf, .CSV,.dwg=application/octet-stream|application/acad")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		".pdf": {"application/pdf"},
+		".csv": {"text/plain"},
+		".dwg": {"application/octet-stream", "application/acad"},
+	}, types)
+
+	_, err = parseAttachmentTypes(".exe")
+	assert.Error(t, err)
+}
+
+func TestAttachments(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+	pdf := []byte("%PDF-1.4\n%test\n")
+
+	src, err := storeAttachment("foo/test", "Q3 Report (final).PDF", pdf)
+	require.NoError(t, err)
+	assert.Equal(t, "/attachments/Q3-Report-final.pdf", src)
+
+	// Re-uploading the same file reuses it, a different file with the same name doesn't overwrite it
+	src, err = storeAttachment("foo/test", "Q3 Report (final).PDF", pdf)
+	require.NoError(t, err)
+	assert.Equal(t, "/attachments/Q3-Report-final.pdf", src)
+
+	src, err = storeAttachment("foo/test", "Q3-Report-final.pdf", []byte("%PDF-1.4\n%other\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/attachments/Q3-Report-final-1.pdf", src)
+
+	// Only allowed extensions with matching content are accepted
+	var rejected *userError
+	_, err = storeAttachment("foo/test", "script.sh", []byte("#!/bin/sh\n"))
+	assert.True(t, errors.As(err, &rejected))
+
+	_, err = storeAttachment("foo/test", "fake.pdf", []byte("<html><script></script></html>"))
+	assert.True(t, errors.As(err, &rejected))
+
+	_, err = storeAttachment("foo/test", "diagram.drawio", []byte(`<?xml version="1.0"?><x:script xmlns:x="http://www.w3.org/1999/xhtml">alert(1)</x:script>`))
+	assert.True(t, errors.As(err, &rejected))
+
+	_, err = storeAttachment("foo/test", "diagram.drawio", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`))
+	assert.True(t, errors.As(err, &rejected))
+
+	_, err = storeAttachment("foo/test", "diagram.drawio", []byte(`<?xml version="1.0"?><?xml-stylesheet type="text/xsl" href="x.xsl"?><mxfile/>`))
+	assert.True(t, errors.As(err, &rejected))
+
+	src, err = storeAttachment("foo/test", "diagram.drawio", []byte(`<mxfile host="app.diagrams.net"><diagram></diagram></mxfile>`))
+	require.NoError(t, err)
+	assert.Equal(t, "/attachments/diagram.drawio", src)
+
+	// Diagrams saved by the desktop app start with an xml declaration
+	src, err = storeAttachment("foo/test", "saved.drawio", []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<mxfile host=\"Electron\"><diagram id=\"a\" name=\"Page-1\"><mxGraphModel><root><mxCell id=\"0\" value=\"&lt;b&gt;label&lt;/b&gt;\"/></root></mxGraphModel></diagram></mxfile>\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/attachments/saved.drawio", src)
+
+	_, err = storeAttachment("foo/missing", "report.pdf", pdf)
+	assert.Error(t, err)
+
+	// Bundled pages keep attachments next to their index.md
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "foo", "bundle"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "index.md"), []byte("leaf\n"), 0644))
+
+	src, err = storeAttachment("foo/bundle", "../../data.csv", []byte("a,b\n1,2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "data.csv", src)
+
+	// Attachments are committed once a page links to them
+	err = stageUpdate("foo/test", `<p>See <a href="/attachments/Q3-Report-final.pdf">Q3-Report-final.pdf</a></p>`, "user@test.com")
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\nSee [Q3-Report-final.pdf](/attachments/Q3-Report-final.pdf)\n", string(raw))
+
+	out, err := exec.Command("git", "show", "--name-only", "--format=").CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "content/foo/test.md\nstatic/attachments/Q3-Report-final.pdf\n", string(out))
+}
+
+func TestServeRepoFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "diagram.drawio")
+	require.NoError(t, os.WriteFile(file, []byte(`<mxfile></mxfile>`), 0644))
+
+	w := httptest.NewRecorder()
+	serveRepoFile(w, httptest.NewRequest("GET", "/attachments/diagram.drawio", nil), file)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+}
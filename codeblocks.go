@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	htmlpkg "html"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	htmlnode "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	codeLanguageRegex = regexp.MustCompile(`<pre><code(?: class="language-([^"]+)")?>`)
+	codeClassRegex    = regexp.MustCompile(`(?:^|\s)language-(\S+)`)
+	backtickRunRegex  = regexp.MustCompile("`{3,}")
+)
+
+// editorCodeBlocks moves the language of the code blocks rendered by mdToHTML to where the editor expects it.
+// The full info strings of md's fenced code blocks (e.g. "go {linenos=true}") are used when they line up, mermaid
+// blocks aside since they're placeholders.
+func editorCodeBlocks(html, md string) string {
+	var infos []string
+	ast.WalkFunc(parseMarkdown(md), func(node ast.Node, entering bool) ast.WalkStatus {
+		if block, ok := node.(*ast.CodeBlock); ok && entering && fenceLanguage(string(block.Info)) != "mermaid" {
+			infos = append(infos, strings.TrimSpace(string(block.Info)))
+		}
+		return ast.GoToNext
+	})
+	if len(infos) != len(codeLanguageRegex.FindAllStringIndex(html, -1)) {
+		infos = nil
+	}
+
+	i := 0
+	return codeLanguageRegex.ReplaceAllStringFunc(html, func(match string) string {
+		language := codeLanguageRegex.FindStringSubmatch(match)[1]
+		if infos != nil && infos[i] != "" {
+			language = htmlpkg.EscapeString(infos[i])
+		}
+		i++
+		if language == "" {
+			return match
+		}
+		return fmt.Sprintf(`<pre data-language="%s"><code>`, language)
+	})
+}
+
+// codeBlockMarkdown converts a pre element, or the code block container of the editor, to a fenced code block.
+// The code is kept exactly as is.
+func codeBlockMarkdown(node *htmlnode.Node) string {
+	var lines []string
+	var language string
+	if node.DataAtom == atom.Pre {
+		language = attr(node, "data-language")
+		code := node
+		if child := node.FirstChild; child != nil && child.DataAtom == atom.Code && child.NextSibling == nil {
+			code = child
+			if match := codeClassRegex.FindStringSubmatch(attr(child, "class")); match != nil && language == "" {
+				language = match[1]
+			}
+		}
+		lines = strings.Split(strings.TrimSuffix(textContent(code), "\n"), "\n")
+	} else {
+		// Each line of the editor's code blocks is an element of its own
+		for line := node.FirstChild; line != nil; line = line.NextSibling {
+			if line.Type != htmlnode.ElementNode {
+				continue
+			}
+			if language == "" {
+				language = attr(line, "data-language")
+			}
+			lines = append(lines, textContent(line))
+		}
+	}
+	if language == "plain" {
+		language = ""
+	}
+
+	// The fence has to be longer than any run of backticks in the code
+	code := strings.Join(lines, "\n")
+	fence := "```"
+	for _, run := range backtickRunRegex.FindAllString(code, -1) {
+		if len(run) >= len(fence) {
+			fence = strings.Repeat("`", len(run)+1)
+		}
+	}
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+// textContent returns the text of a node and its descendants.
+func textContent(node *htmlnode.Node) string {
+	if node.Type == htmlnode.TextNode {
+		return node.Data
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
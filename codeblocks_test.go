@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditorCodeBlocks(t *testing.T) {
+	md := "```bash\necho   \"hi\"\n\n  ls\n```\n\n```\nplain\n```\n\n```go {linenos=true, hl_lines=\"2\"}\nx\n```\n"
+	html := editorCodeBlocks(mdToHTML(md), md)
+	assert.Equal(t, "<pre data-language=\"bash\"><code>echo   &quot;hi&quot;\n\n  ls\n</code></pre>\n\n<pre><code>plain\n</code></pre>\n\n"+
+		"<pre data-language=\"go {linenos=true, hl_lines=&#34;2&#34;}\"><code>x\n</code></pre>\n", html)
+}
+
+func TestConvertCodeBlocks(t *testing.T) {
+	// As submitted by the editor
+	html := `<p>run</p><div class="ql-code-block-container" spellcheck="false">` +
+		`<div class="ql-code-block" data-language="bash">echo   "hi" &amp;&amp; \</div>` +
+		`<div class="ql-code-block" data-language="bash"><br></div>` +
+		`<div class="ql-code-block" data-language="bash">  ls *.md</div>` +
+		`</div><div class="ql-code-block-container"><div class="ql-code-block" data-language="plain">` + "```" + `</div></div>`
+
+	converted, sources, err := convertBlocks(html, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>run</p><p>XPROTECTED0X</p><p>XPROTECTED1X</p>", converted)
+	assert.Equal(t, []string{"```bash\necho   \"hi\" && \\\n\n  ls *.md\n```", "````\n```\n````"}, sources)
+
+	// As rendered by mdToHTML
+	md := "```go\nfunc main() {\n\t// <hi>\n}\n```\n"
+	_, sources, err = convertBlocks(editorCodeBlocks(mdToHTML(md), md), "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"```go\nfunc main() {\n\t// <hi>\n}\n```"}, sources)
+}
+
+func TestCodeBlockRoundTrip(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte("# hello\n\n~~~sh {linenos=true}\nfoo   *bar*\n~~~\n"), 0644))
+
+	html, _, err := readPage("foo/test")
+	require.NoError(t, err)
+	require.NoError(t, stageUpdate("foo/test", html, "user@test.com"))
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n~~~sh {linenos=true}\nfoo   *bar*\n~~~\n", string(raw))
+
+	// Edited code isn't reflowed, and keeps its info string
+	assert.Contains(t, html, `<pre data-language="sh {linenos=true}">`)
+	require.NoError(t, stageUpdate("foo/test", `<h1>hello</h1><div class="ql-code-block-container">`+
+		`<div class="ql-code-block" data-language="sh {linenos=true}">foo   *bar*</div><div class="ql-code-block" data-language="sh {linenos=true}">    baz</div></div>`, "user@test.com"))
+
+	raw, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n```sh {linenos=true}\nfoo   *bar*\n    baz\n```\n", string(raw))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	htmlnode "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// lostContent is something a page had before an update that it doesn't have after, e.g. because the editor couldn't
+// represent it.
+type lostContent struct {
+	Kind   string // heading, code block, table, shortcode, link or image
+	Source string
+}
+
+// Diff returns the content as removed lines.
+func (l lostContent) Diff() string {
+	return "- " + strings.ReplaceAll(strings.ReplaceAll(l.Source, "\r\n", "\n"), "\n", "\n- ")
+}
+
+// Kinds of content that updates are checked for, in the order they're reported
+var lostContentKinds = []string{"heading", "code block", "table", "shortcode", "link", "image"}
+
+// findLostContent returns the headings, code blocks, tables, shortcodes, links and images that the html submitted by
+// the editor still has, but the markdown it was converted to doesn't, i.e. content the conversion dropped. Content the
+// user deleted isn't in the submitted html, so it isn't reported. Items that weren't edited are shown with their
+// source in the previous version of the markdown.
+func findLostContent(previous, html, md string) ([]lostContent, error) {
+	submitted, err := htmlInventory(html)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := htmlInventory(editorHTML(md))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]string{}
+	for kind, items := range contentInventory(previous) {
+		for _, item := range items {
+			if kind == "link" || kind == "image" {
+				sources[kind+"\x00"+item] = item
+				continue
+			}
+			if keys, err := htmlInventory(editorHTML(item)); err == nil && len(keys[kind]) > 0 {
+				sources[kind+"\x00"+keys[kind][0]] = item
+			}
+		}
+	}
+
+	var lost []lostContent
+	for _, kind := range lostContentKinds {
+		remaining := map[string]int{}
+		for _, key := range converted[kind] {
+			remaining[key]++
+		}
+		for _, key := range submitted[kind] {
+			if remaining[key] > 0 {
+				remaining[key]--
+				continue
+			}
+			source, ok := sources[kind+"\x00"+key]
+			if !ok {
+				source = key
+			}
+			lost = append(lost, lostContent{Kind: kind, Source: source})
+		}
+	}
+	return lost, nil
+}
+
+// htmlInventory returns keys for the semantically important parts of the editor's html by kind, in order. Headings,
+// code blocks and tables are keyed by their text, since the editor's markup for them differs from the markdown's.
+func htmlInventory(html string) (map[string][]string, error) {
+	nodes, err := htmlnode.ParseFragment(strings.NewReader(html), &htmlnode.Node{Type: htmlnode.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return nil, fmt.Errorf("parsing html: %w", err)
+	}
+
+	items := map[string][]string{}
+	var visit func(node *htmlnode.Node)
+	visit = func(node *htmlnode.Node) {
+		if node.Type != htmlnode.ElementNode {
+			return
+		}
+		switch {
+		case hasClass(node, "ql-protected") || hasClass(node, "ql-protected-inline") || hasClass(node, "ql-diagram"):
+			source := strings.TrimSpace(strings.ReplaceAll(attr(node, "data-source"), "\r\n", "\n"))
+			switch {
+			case strings.HasPrefix(source, "{{"):
+				items["shortcode"] = append(items["shortcode"], source)
+			case strings.HasPrefix(source, "```") || strings.HasPrefix(source, "~~~"):
+				items["code block"] = append(items["code block"], inventoryText(node))
+			}
+			return
+		case node.DataAtom == atom.Pre || hasClass(node, "ql-code-block-container"):
+			items["code block"] = append(items["code block"], inventoryText(node))
+			return
+		case node.DataAtom == atom.H1 || node.DataAtom == atom.H2 || node.DataAtom == atom.H3 ||
+			node.DataAtom == atom.H4 || node.DataAtom == atom.H5 || node.DataAtom == atom.H6:
+			items["heading"] = append(items["heading"], node.Data+" "+inventoryText(node))
+		case node.DataAtom == atom.Table:
+			items["table"] = append(items["table"], inventoryText(node))
+		case node.DataAtom == atom.A:
+			if href := attr(node, "href"); href != "" && !strings.Contains(href, "{{") && !strings.Contains(href, tokenPrefix) {
+				items["link"] = append(items["link"], href)
+			}
+		case node.DataAtom == atom.Img:
+			items["image"] = append(items["image"], attr(node, "src"))
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	for _, node := range nodes {
+		visit(node)
+	}
+	return items, nil
+}
+
+// inventoryText returns the text of a node with whitespace collapsed, and placeholders replaced by their source.
+// The editor's controls (e.g. the language picker of code blocks) are skipped.
+func inventoryText(node *htmlnode.Node) string {
+	var text strings.Builder
+	var visit func(node *htmlnode.Node)
+	visit = func(node *htmlnode.Node) {
+		switch {
+		case node.Type == htmlnode.TextNode:
+			text.WriteString(node.Data)
+			return
+		case node.Type != htmlnode.ElementNode:
+			return
+		case hasClass(node, "ql-ui"):
+			return
+		case hasClass(node, "ql-protected") || hasClass(node, "ql-protected-inline") || hasClass(node, "ql-diagram"):
+			text.WriteString(" " + attr(node, "data-source") + " ")
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+			switch child.DataAtom {
+			case atom.Td, atom.Th, atom.Tr, atom.Div, atom.P, atom.Li, atom.Br:
+				text.WriteString(" ") // cells and lines of code
+			}
+		}
+	}
+	visit(node)
+	return strings.Join(strings.Fields(strings.ReplaceAll(text.String(), "\ufeff", "")), " ")
+}
+
+// contentInventory returns the source of the semantically important parts of a markdown document by kind, in order.
+func contentInventory(md string) map[string][]string {
+	items := map[string][]string{}
+
+	_, blocks := splitBlocks(md)
+	for _, block := range blocks {
+		children := parseMarkdown(block.Text).GetChildren()
+		if len(children) == 0 {
+			continue
+		}
+		switch children[0].(type) {
+		case *ast.Heading:
+			items["heading"] = append(items["heading"], block.Text)
+		case *ast.CodeBlock:
+			items["code block"] = append(items["code block"], block.Text)
+		case *ast.Table:
+			items["table"] = append(items["table"], block.Text)
+		}
+	}
+
+	for _, r := range findProtected(md) {
+		if source := md[r.Start:r.End]; strings.HasPrefix(source, "{{") {
+			items["shortcode"] = append(items["shortcode"], source)
+		}
+	}
+
+	ast.WalkFunc(parseMarkdown(md), func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			if dest := string(n.Destination); !strings.HasPrefix(dest, "{{") {
+				items["link"] = append(items["link"], dest)
+			}
+		case *ast.Image:
+			items["image"] = append(items["image"], string(n.Destination))
+		}
+		return ast.GoToNext
+	})
+	return items
+}
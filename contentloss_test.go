@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindLostContent(t *testing.T) {
+	previous := "# Intro\n\nSee [docs](/docs/) and {{< ref \"x\" >}}.\n\n" +
+		"| a | b |\n|---|---|\n| 1 | 2 |\n\n" +
+		"```bash\necho hi\n```\n\n![diagram](/images/d.png)\n\n## Details\n\nText\n"
+
+	// Editing or deleting things isn't a loss
+	edited := "# Introduction\n\nSee [docs](/docs/v2/) and {{< ref \"x\" >}}.\n\n" +
+		"| a | b |\n|---|---|\n| 1 | 3 |\n\n" +
+		"```bash\necho hello\n```\n\n![diagram](/images/d.png)\n\n## Details\n\nMore text\n"
+	lost, err := findLostContent(previous, editorHTML(edited), edited)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+
+	deleted := "# Intro\n\nSee docs.\n\nText\n"
+	lost, err = findLostContent(previous, editorHTML(deleted), deleted)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+
+	// Dropping them while converting the editor's html is
+	dropped := "# Intro\n\nSee docs and .\n\na b 1 2\n\necho hi\n\n## Details\n\nText\n"
+	lost, err = findLostContent(previous, editorHTML(previous), dropped)
+	require.NoError(t, err)
+	assert.Equal(t, []lostContent{
+		{Kind: "code block", Source: "```bash\necho hi\n```"},
+		{Kind: "table", Source: "| a | b |\n|---|---|\n| 1 | 2 |"},
+		{Kind: "shortcode", Source: "{{< ref \"x\" >}}"},
+		{Kind: "link", Source: "/docs/"},
+		{Kind: "image", Source: "/images/d.png"},
+	}, lost)
+
+	lost, err = findLostContent(previous, editorHTML(previous), "# Intro\n\nSee [docs](/docs/) and {{< ref \"x\" >}}.\n\n"+
+		"| a | b |\n|---|---|\n| 1 | 2 |\n\n```bash\necho hi\n```\n\n![diagram](/images/d.png)\n\nText\n")
+	require.NoError(t, err)
+	assert.Equal(t, []lostContent{{Kind: "heading", Source: "## Details"}}, lost)
+
+	// A dropped table isn't hidden by an edited one next to it, and edited items are shown as submitted
+	tables := "| a |\n|---|\n| 1 |\n\n| b |\n|---|\n| 2 |\n"
+	lost, err = findLostContent(tables, editorHTML("| a |\n|---|\n| 10 |\n\n| b |\n|---|\n| 2 |\n"), "| a |\n|---|\n| 10 |\n")
+	require.NoError(t, err)
+	assert.Equal(t, []lostContent{{Kind: "table", Source: "| b |\n|---|\n| 2 |"}}, lost)
+
+	lost, err = findLostContent(tables, editorHTML("| a |\n|---|\n| 10 |\n\n| b |\n|---|\n| 20 |\n"), "| a |\n|---|\n| 10 |\n")
+	require.NoError(t, err)
+	assert.Equal(t, []lostContent{{Kind: "table", Source: "b 20"}}, lost)
+
+	assert.Equal(t, "- | a |\n- | 1 |", lostContent{Kind: "table", Source: "| a |\r\n| 1 |"}.Diff())
+}
+
+func TestReviewLostContent(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte("# hello\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"), 0644))
+
+	// Deleting the table is intentional
+	_, lost, err := reviewUpdate("foo/test", "<h1>hello</h1><p>a b 1 2</p>")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+
+	// Links without text are dropped by the conversion
+	_, lost, err = reviewUpdate("foo/test", "<h1>hello</h1><p>see <a href=\"/foo/\"></a></p>")
+	require.NoError(t, err)
+	assert.Equal(t, []lostContent{{Kind: "link", Source: "/foo/"}}, lost)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Scripts used to preview math and diagrams in the editor, and their subresource integrity hashes. Previews show
+// the source if they're empty.
+var (
+	katexURL         string
+	katexIntegrity   string // of katex.min.js
+	mermaidURL       string
+	mermaidIntegrity string
+)
+
+// requiresIntegrity returns true for script URLs on other sites, which could run anything as the editor if they were
+// compromised.
+func requiresIntegrity(script string) bool {
+	u, err := url.Parse(script)
+	return err != nil || u.Host != "" || u.Scheme != ""
+}
+
+// diagramKind returns "math" for $$ math blocks and "mermaid" for mermaid code blocks, which are edited as source
+// with a preview.
+func diagramKind(source string) string {
+	trimmed := strings.TrimSpace(source)
+	switch {
+	case strings.HasPrefix(trimmed, "$$"):
+		return "math"
+	case fenceRegex.MatchString(trimmed) && fenceLanguage(strings.SplitN(trimmed, "\n", 2)[0]) == "mermaid":
+		return "mermaid"
+	default:
+		return ""
+	}
+}
+
+// fenceLanguage returns the language of a code fence line e.g. "go" for "```go {linenos=true}".
+func fenceLanguage(line string) string {
+	info := strings.TrimLeft(strings.TrimSpace(line), "`~")
+	if fields := strings.Fields(info); len(fields) > 0 {
+		return strings.Trim(fields[0], "{}")
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagramKind(t *testing.T) {
+	assert.Equal(t, "math", diagramKind("$$\nx^2\n$$"))
+	assert.Equal(t, "math", diagramKind("$$x^2$$"))
+	assert.Equal(t, "mermaid", diagramKind("```mermaid\ngraph TD\n```"))
+	assert.Equal(t, "mermaid", diagramKind("~~~ {mermaid}\ngraph TD\n~~~"))
+	assert.Equal(t, "", diagramKind("```go\nfunc main() {}\n```"))
+	assert.Equal(t, "", diagramKind("{{< figure >}}"))
+}
+
+func TestRequiresIntegrity(t *testing.T) {
+	assert.True(t, requiresIntegrity("https://cdn.jsdelivr.net/npm/mermaid@11.4.1/dist/mermaid.min.js"))
+	assert.True(t, requiresIntegrity("//cdn.example.com/katex"))
+	assert.False(t, requiresIntegrity("/katex"))
+	assert.False(t, requiresIntegrity("/js/mermaid.min.js"))
+}
+
+func TestProtectDiagrams(t *testing.T) {
+	md := "Text with $x$ for $5 and $10.\n\n$$\n\\frac{a}{b}\n\n+ c\n$$\n\n$$E = mc^2$$\n\n```mermaid\ngraph TD\n    A[<b>start</b>] --> B\n```\n\n```go {linenos=true}\nx\n```\n"
+	protected, sources := protectMarkdown(md, "")
+	assert.Equal(t, "Text with XPROTECTED0X for $5 and $10.\n\nXPROTECTED1X\n\nXPROTECTED2X\n\nXPROTECTED3X\n\n```go {linenos=true}\nx\n```\n", protected)
+	assert.Equal(t, []string{"$x$", "$$\n\\frac{a}{b}\n\n+ c\n$$", "$$E = mc^2$$", "```mermaid\ngraph TD\n    A[<b>start</b>] --> B\n```"}, sources)
+
+	html := editorHTML(md)
+	assert.Contains(t, html, `<div class="ql-diagram" data-source="$$E = mc^2$$"></div>`)
+	assert.Contains(t, html, "<div class=\"ql-diagram\" data-source=\"```mermaid\ngraph TD\n    A[&lt;b&gt;start&lt;/b&gt;] --&gt; B\n```\"></div>")
+	assert.Contains(t, html, `<pre data-language="go {linenos=true}">`)
+}
+
+func TestDiagramRoundTrip(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	md := "# hello\n\n$$\n\\sum_{i=0}^n i\n$$\n\n```mermaid\nsequenceDiagram\n    A->>B: *hi*\n```\n"
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte(md), 0644))
+
+	html, _, err := readPage("foo/test")
+	require.NoError(t, err)
+	require.NoError(t, stageUpdate("foo/test", html, "user@test.com"))
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, md, string(raw))
+
+	// Edited diagrams are written exactly as given
+	require.NoError(t, stageUpdate("foo/test", `<h1>hello</h1>`+
+		`<div class="ql-diagram" data-source="$$&#10;\sum_{i=1}^n i_*&#10;$$" contenteditable="false"><span class="katex">...</span></div>`+
+		`<div class="ql-diagram" data-source="`+"```"+`mermaid&#10;sequenceDiagram&#10;    A-&gt;&gt;B: *bye*&#10;`+"```"+`"><svg></svg></div>`, "user@test.com"))
+
+	raw, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n$$\n\\sum_{i=1}^n i_*\n$$\n\n```mermaid\nsequenceDiagram\n    A->>B: *bye*\n```\n", string(raw))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	htmlnode "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	taskItemRegex      = regexp.MustCompile(`<li>(<p>)?\[([ xX])\] `)
+	calloutRegex       = regexp.MustCompile(`<blockquote>\s*<p>(\[![a-zA-Z]+\][+-]?)\n`)
+	indentClassRegex   = regexp.MustCompile(`(?:^|\s)ql-indent-(\d+)`)
+	escapedTaskRegex   = regexp.MustCompile(`(?m)^(\s*(?:[-*+]|\d+[.)]) )\\\[([ xX])\]`)
+	escapedNoteRegex   = regexp.MustCompile(`\\\[\^([^\]\s]+)\]`)
+	escapedAlertRegex  = regexp.MustCompile(`(?m)^((?:> ?)+)\\\[!([a-zA-Z]+)\]`)
+	nestedListGapRegex = regexp.MustCompile(`(?m)^([ \t]*(?:[-*+]|\d+[.)]) .*)\n[ \t]*\n([ \t]+(?:[-*+]|\d+[.)]) )`)
+	listEndRegex       = regexp.MustCompile(`\n\n<!--THE END-->\n\n((?:[-*+]|\d+[.)]) )`)
+	listItemRegex      = regexp.MustCompile(`^(?:[-*+]|\d+[.)]) `)
+	emptyQuoteRegex    = regexp.MustCompile(`(?m)^(>+) +$`)
+	alertGapRegex      = regexp.MustCompile(`(?m)^(>+ ?\[![a-zA-Z]+\][+-]?)\n>+\n`)
+)
+
+// editorHTML renders a markdown document to html for the editor.
+func editorHTML(md string) string {
+	html := editorTables(renderProtected(md))
+	html = editorCodeBlocks(html, md)
+
+	// Task list items and the first line of callouts, which gomarkdown renders as text
+	html = taskItemRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := taskItemRegex.FindStringSubmatch(match)
+		if parts[2] == " " {
+			return `<li data-list="unchecked">` + parts[1]
+		}
+		return `<li data-list="checked">` + parts[1]
+	})
+	return calloutRegex.ReplaceAllString(html, "<blockquote>\n<p>$1</p>\n<p>")
+}
+
+// semanticHTML converts the markup of the editor that htmltomarkdown doesn't understand: flat lists with indent
+// classes, blockquotes with an element per line, and strikethrough. Underlines and highlights have no markdown
+// equivalent, so they're kept as inline html (tokens, returned along with the given sources) if the site renders it,
+// and dropped otherwise.
+func semanticHTML(html, nonce string, sources []string) (string, []string, error) {
+	if !strings.Contains(html, "data-list") && !strings.Contains(html, "<blockquote") && !strings.Contains(html, "<s>") &&
+		!strings.Contains(html, "<del>") && !strings.Contains(html, "<u>") && !strings.Contains(html, "<mark>") {
+		return html, sources, nil
+	}
+
+	nodes, err := htmlnode.ParseFragment(strings.NewReader(html), &htmlnode.Node{Type: htmlnode.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing html: %w", err)
+	}
+	root := &htmlnode.Node{Type: htmlnode.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, node := range nodes {
+		root.AppendChild(node)
+	}
+
+	// unwrap replaces an element with its children, between the given text nodes
+	unwrap := func(node *htmlnode.Node, before, after string) {
+		parent := node.Parent
+		if before != "" {
+			parent.InsertBefore(&htmlnode.Node{Type: htmlnode.TextNode, Data: before}, node)
+		}
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			parent.InsertBefore(child, node)
+		}
+		if after != "" {
+			parent.InsertBefore(&htmlnode.Node{Type: htmlnode.TextNode, Data: after}, node)
+		}
+		parent.RemoveChild(node)
+	}
+
+	var visit func(node *htmlnode.Node)
+	visit = func(node *htmlnode.Node) {
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			switch {
+			case child.Type != htmlnode.ElementNode:
+			case (child.DataAtom == atom.Ol || child.DataAtom == atom.Ul) && isEditorList(child):
+				for _, list := range nestLists(child) {
+					node.InsertBefore(list, child)
+					visit(list)
+				}
+				node.RemoveChild(child)
+			case child.DataAtom == atom.Blockquote:
+				next = mergeBlockquotes(child)
+				visit(child)
+			case child.DataAtom == atom.S || child.DataAtom == atom.Del || child.DataAtom == atom.Strike:
+				visit(child)
+				unwrap(child, "~~", "~~")
+			case (child.DataAtom == atom.U || child.DataAtom == atom.Mark) && site.UnsafeHTML:
+				visit(child)
+				open, close := protectedToken(nonce, len(sources)), protectedToken(nonce, len(sources)+1)
+				sources = append(sources, "<"+child.Data+">", "</"+child.Data+">")
+				unwrap(child, open, close)
+			case child.DataAtom == atom.U || child.DataAtom == atom.Mark:
+				visit(child)
+				unwrap(child, "", "")
+			default:
+				visit(child)
+			}
+			child = next
+		}
+	}
+	visit(root)
+
+	var out strings.Builder
+	for node := root.FirstChild; node != nil; node = node.NextSibling {
+		if err := htmlnode.Render(&out, node); err != nil {
+			return "", nil, fmt.Errorf("rendering html: %w", err)
+		}
+	}
+	return out.String(), sources, nil
+}
+
+// isEditorList returns true for the editor's lists, whose items hold their type and have an indent class instead
+// of being nested.
+func isEditorList(list *htmlnode.Node) bool {
+	for li := list.FirstChild; li != nil; li = li.NextSibling {
+		if li.DataAtom == atom.Li && attr(li, "data-list") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// nestLists converts one of the editor's lists to nested ul and ol lists. Task list items are prefixed with their
+// [ ] or [x] marker.
+func nestLists(list *htmlnode.Node) []*htmlnode.Node {
+	type level struct {
+		list   *htmlnode.Node
+		indent int
+	}
+	var roots []*htmlnode.Node
+	var stack []level
+
+	for li := list.FirstChild; li != nil; {
+		next := li.NextSibling
+		list.RemoveChild(li)
+		if li.DataAtom != atom.Li {
+			li = next
+			continue
+		}
+
+		kind := attr(li, "data-list")
+		indent := 0
+		if match := indentClassRegex.FindStringSubmatch(attr(li, "class")); match != nil {
+			indent, _ = strconv.Atoi(match[1])
+		}
+		tag, tagAtom := "ul", atom.Ul
+		if kind == "ordered" {
+			tag, tagAtom = "ol", atom.Ol
+		}
+
+		// Drop the editor's markup
+		li.Attr = nil
+		for child := li.FirstChild; child != nil; {
+			nextChild := child.NextSibling
+			if child.Type == htmlnode.ElementNode && hasClass(child, "ql-ui") {
+				li.RemoveChild(child)
+			}
+			child = nextChild
+		}
+		switch kind {
+		case "checked":
+			li.InsertBefore(&htmlnode.Node{Type: htmlnode.TextNode, Data: "[x] "}, li.FirstChild)
+		case "unchecked":
+			li.InsertBefore(&htmlnode.Node{Type: htmlnode.TextNode, Data: "[ ] "}, li.FirstChild)
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 && stack[len(stack)-1].indent == indent && stack[len(stack)-1].list.DataAtom != tagAtom {
+			stack = stack[:len(stack)-1] // a list of another type
+		}
+		if len(stack) == 0 || stack[len(stack)-1].indent < indent {
+			nested := &htmlnode.Node{Type: htmlnode.ElementNode, Data: tag, DataAtom: tagAtom}
+			if len(stack) > 0 && stack[len(stack)-1].list.LastChild != nil {
+				stack[len(stack)-1].list.LastChild.AppendChild(nested)
+			} else {
+				roots = append(roots, nested)
+			}
+			stack = append(stack, level{list: nested, indent: indent})
+		}
+		stack[len(stack)-1].list.AppendChild(li)
+		li = next
+	}
+	return roots
+}
+
+// mergeBlockquotes merges the blockquotes that follow a blockquote into it, since the editor has one per line.
+// Each line becomes a paragraph. Returns the node after the merged blockquotes.
+func mergeBlockquotes(quote *htmlnode.Node) *htmlnode.Node {
+	wrapLines := func(q *htmlnode.Node) []*htmlnode.Node {
+		for child := q.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == htmlnode.ElementNode && child.DataAtom == atom.P {
+				// Already made of blocks
+				var children []*htmlnode.Node
+				for child := q.FirstChild; child != nil; child = q.FirstChild {
+					q.RemoveChild(child)
+					children = append(children, child)
+				}
+				return children
+			}
+		}
+		p := &htmlnode.Node{Type: htmlnode.ElementNode, Data: "p", DataAtom: atom.P}
+		for child := q.FirstChild; child != nil; child = q.FirstChild {
+			q.RemoveChild(child)
+			p.AppendChild(child)
+		}
+		return []*htmlnode.Node{p}
+	}
+
+	next := quote.NextSibling
+	if next == nil || next.Type != htmlnode.ElementNode || next.DataAtom != atom.Blockquote {
+		return next
+	}
+
+	lines := wrapLines(quote)
+	for next != nil && next.Type == htmlnode.ElementNode && next.DataAtom == atom.Blockquote {
+		lines = append(lines, wrapLines(next)...)
+		following := next.NextSibling
+		next.Parent.RemoveChild(next)
+		next = following
+	}
+	for _, line := range lines {
+		quote.AppendChild(line)
+	}
+	return next
+}
+
+// tidyMarkdown undoes the escaping of htmltomarkdown for syntax it doesn't know about (task list items, footnotes and
+// callouts). It also removes the blank lines htmltomarkdown puts before nested lists and after the type of callouts,
+// and the comments it puts between lists that don't need them.
+func tidyMarkdown(md string) string {
+	md = escapedTaskRegex.ReplaceAllString(md, "$1[$2]")
+	md = escapedNoteRegex.ReplaceAllString(md, "[^$1]")
+	md = escapedAlertRegex.ReplaceAllString(md, "$1[!$2]")
+	md = emptyQuoteRegex.ReplaceAllString(md, "$1")
+	md = alertGapRegex.ReplaceAllString(md, "$1\n")
+	md = tidyListEnds(md)
+	for {
+		tidied := nestedListGapRegex.ReplaceAllString(md, "$1\n$2")
+		if tidied == md {
+			return md
+		}
+		md = tidied
+	}
+}
+
+// tidyListEnds removes the comments htmltomarkdown puts between adjacent lists if the lists are of different kinds.
+// The comments are kept between lists of the same kind, which would be merged into a single list without them.
+func tidyListEnds(md string) string {
+	var tidied strings.Builder
+	last := 0
+	for _, match := range listEndRegex.FindAllStringSubmatchIndex(md, -1) {
+		previous := previousListItem(md[:match[0]])
+		if previous == "" || isOrderedItem(previous) == isOrderedItem(md[match[2]:match[3]]) {
+			continue
+		}
+		tidied.WriteString(md[last:match[0]])
+		tidied.WriteString("\n\n")
+		last = match[2]
+	}
+	tidied.WriteString(md[last:])
+	return tidied.String()
+}
+
+// previousListItem returns the last line of the markdown that is an item of a top level list.
+func previousListItem(md string) string {
+	for md != "" {
+		i := strings.LastIndex(md, "\n")
+		if line := md[i+1:]; listItemRegex.MatchString(line) {
+			return line
+		}
+		if i < 0 {
+			break
+		}
+		md = md[:i]
+	}
+	return ""
+}
+
+func isOrderedItem(line string) bool {
+	return line != "" && line[0] >= '0' && line[0] <= '9'
+}
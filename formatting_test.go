@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditorHTML(t *testing.T) {
+	html := editorHTML("- [ ] todo\n- [x] done\n- plain\n\n> [!WARNING]\n> Careful\n")
+	assert.Equal(t, "<ul>\n<li data-list=\"unchecked\">todo</li>\n<li data-list=\"checked\">done</li>\n<li>plain</li>\n</ul>\n\n"+
+		"<blockquote>\n<p>[!WARNING]</p>\n<p>Careful</p>\n</blockquote>\n", html)
+}
+
+func TestSemanticHTML(t *testing.T) {
+	ui := `<span class="ql-ui" contenteditable="false"></span>`
+	html, _, err := semanticHTML(`<ol>`+
+		`<li data-list="bullet">`+ui+`one</li>`+
+		`<li data-list="bullet" class="ql-indent-1">`+ui+`nested <s>old</s></li>`+
+		`<li data-list="ordered" class="ql-indent-2">`+ui+`deeper</li>`+
+		`<li data-list="bullet">`+ui+`two</li>`+
+		`<li data-list="unchecked">`+ui+`todo</li>`+
+		`<li data-list="checked" class="ql-indent-1">`+ui+`done</li>`+
+		`<li data-list="ordered">`+ui+`first</li>`+
+		`</ol>`+
+		`<blockquote>[!NOTE]</blockquote><blockquote>Quoted <del>text</del></blockquote><p>after</p>`, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, `<ul><li>one<ul><li>nested ~~old~~<ol><li>deeper</li></ol></li></ul></li><li>two</li><li>[ ] todo<ul><li>[x] done</li></ul></li></ul>`+
+		`<ol><li>first</li></ol>`+
+		`<blockquote><p>[!NOTE]</p><p>Quoted ~~text~~</p></blockquote><p>after</p>`, html)
+
+	// Html without any of the editor's markup is left alone
+	html, _, err = semanticHTML("<ul><li>plain</li></ul>", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "<ul><li>plain</li></ul>", html)
+}
+
+func TestInlineHTMLFormats(t *testing.T) {
+	defer func(c siteConfig) { site = c }(site)
+
+	// Dropped unless the site renders raw html
+	site.UnsafeHTML = false
+	html, sources, err := semanticHTML("<p><u>under</u> and <mark>marked <s>text</s></mark></p>", "", []string{"x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>under and marked ~~text~~</p>", html)
+	assert.Equal(t, []string{"x"}, sources)
+
+	_, sources = protectMarkdown("<u>under</u>\n", "")
+	assert.Equal(t, []string{"<u>", "</u>"}, sources)
+
+	// Otherwise they're kept as inline html, which the editor can format
+	site.UnsafeHTML = true
+	html, sources, err = semanticHTML("<p><u>under</u> and <mark>marked <s>text</s></mark></p>", "", []string{"x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>XPROTECTED1XunderXPROTECTED2X and XPROTECTED3Xmarked ~~text~~XPROTECTED4X</p>", html)
+	assert.Equal(t, []string{"x", "<u>", "</u>", "<mark>", "</mark>"}, sources)
+
+	assert.Equal(t, "<p><u>under</u> <mark>marked</mark></p>\n", editorHTML("<u>under</u> <mark>marked</mark>\n"))
+}
+
+func TestTidyMarkdown(t *testing.T) {
+	assert.Equal(t, "- [ ] todo\n  - [x] done\n    1. deeper\n- two\n\n1. first\n\n> [!NOTE]\n> text[^1]\n\n[^1]: note\n",
+		tidyMarkdown("- \\[ ] todo\n  \n  - \\[x] done\n    \n    1. deeper\n- two\n\n<!--THE END-->\n\n1. first\n\n> \\[!NOTE]\n> \n> text\\[^1]\n\n\\[^1]: note\n"))
+
+	// Adjacent lists of the same kind stay separate
+	assert.Equal(t, "- one\n  1. nested\n\n<!--THE END-->\n\n- two\n\n1. three\n",
+		tidyMarkdown("- one\n  \n  1. nested\n\n<!--THE END-->\n\n- two\n\n<!--THE END-->\n\n1. three\n"))
+}
+
+func TestFormattingRoundTrip(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	md := "# hello\n\n### Three\n\n###### Six\n\n- [ ] todo\n- [x] done\n  - nested\n\n1. one\n2. two\n\n" +
+		"> [!TIP]\n> Use `code` and ~~strike~~ with a [link](https://example.com).\n\nA footnote[^1].\n\n[^1]: The note.\n"
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte(md), 0644))
+
+	// Saving the page as loaded doesn't change it
+	html, _, err := readPage("foo/test")
+	require.NoError(t, err)
+	require.NoError(t, stageUpdate("foo/test", html, "user@test.com"))
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, md, string(raw))
+
+	// Markup from the editor is converted
+	ui := `<span class="ql-ui" contenteditable="false"></span>`
+	require.NoError(t, stageUpdate("foo/test", `<h1>hello</h1><h3>Three</h3><h6>Six</h6>`+
+		`<ol><li data-list="unchecked">`+ui+`todo</li><li data-list="checked" class="ql-indent-1">`+ui+`done</li><li data-list="ordered">`+ui+`one</li></ol>`+
+		`<blockquote>[!TIP]</blockquote><blockquote>Use <code>code</code> and <s>strike</s></blockquote>`+
+		`<p>A footnote[^1].</p><p>[^1]: The note.</p>`, "user@test.com"))
+
+	raw, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n### Three\n\n###### Six\n\n- [ ] todo\n  - [x] done\n\n1. one\n\n> [!TIP]\n> Use `code` and ~~strike~~\n\nA footnote[^1].\n\n[^1]: The note.\n", string(raw))
+}
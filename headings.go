@@ -0,0 +1,89 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+var (
+	atxHeadingRegex = regexp.MustCompile(`^#{1,6}(\s.*)?$`)
+	headingIDRegex  = regexp.MustCompile(`\s*\{#[^}]*\}\s*$`)
+	fenceRegex      = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+)
+
+// heading is a top level heading of a markdown document.
+type heading struct {
+	Text string
+	ID   string
+}
+
+// markdownHeadings returns the top level headings of a markdown document, with their (possibly generated) ids.
+func markdownHeadings(md string) []heading {
+	var headings []heading
+	for _, node := range parseMarkdown(md).GetChildren() {
+		h, ok := node.(*ast.Heading)
+		if !ok {
+			continue
+		}
+
+		var text strings.Builder
+		ast.WalkFunc(h, func(node ast.Node, entering bool) ast.WalkStatus {
+			if leaf := node.AsLeaf(); leaf != nil && entering {
+				text.Write(leaf.Literal)
+			}
+			return ast.GoToNext
+		})
+		headings = append(headings, heading{Text: strings.TrimSpace(text.String()), ID: h.HeadingID})
+	}
+	return headings
+}
+
+// preserveHeadingIDs keeps the anchors of the headings in the previous version of a document, so editing a
+// heading's text doesn't break links to it. Headings are matched up by their text (see alignSequences). Matched headings whose id would change are given the old id with an explicit {#id}.
+// md must use ATX headings, as generated by htmltomarkdown.
+func preserveHeadingIDs(md, previous string) string {
+	before := markdownHeadings(previous)
+	after := markdownHeadings(md)
+
+	// Find the ATX heading lines of the new document, which should line up with its top level headings
+	lines := strings.Split(md, "\n")
+	var headingLines []int
+	fenced := ""
+	for i, line := range lines {
+		if match := fenceRegex.FindStringSubmatch(line); match != nil {
+			switch fenced {
+			case "":
+				fenced = match[1]
+			case match[1]:
+				fenced = ""
+			}
+			continue
+		}
+		if fenced == "" && atxHeadingRegex.MatchString(line) {
+			headingLines = append(headingLines, i)
+		}
+	}
+	if len(headingLines) != len(after) {
+		return md // unexpected, better to leave the document alone
+	}
+
+	var beforeText, afterText []string
+	for _, h := range before {
+		beforeText = append(beforeText, h.Text)
+	}
+	for _, h := range after {
+		afterText = append(afterText, h.Text)
+	}
+
+	for _, pair := range alignSequences(beforeText, afterText) {
+		old, current := before[pair[0]], after[pair[1]]
+		if old.ID == "" || old.ID == current.ID {
+			continue
+		}
+		i := headingLines[pair[1]]
+		lines[i] = headingIDRegex.ReplaceAllString(strings.TrimRight(lines[i], " \t"), "") + " {#" + old.ID + "}"
+	}
+	return strings.Join(lines, "\n")
+}
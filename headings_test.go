@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreserveHeadingIDs(t *testing.T) {
+	for _, tc := range []struct{ name, previous, md, expected string }{
+		{
+			name:     "unchanged",
+			previous: "# Intro\n\ntext\n\n## Usage\n",
+			md:       "# Intro\n\ntext\n\n## Usage",
+			expected: "# Intro\n\ntext\n\n## Usage",
+		},
+		{
+			name:     "renamed",
+			previous: "# Intro\n\n## Usage\n\n## Config\n",
+			md:       "# Intro\n\n## Usage guide\n\n## Config",
+			expected: "# Intro\n\n## Usage guide {#usage}\n\n## Config",
+		},
+		{
+			name:     "inserted and removed",
+			previous: "# Intro\n\n## Usage\n\n## Old\n\n## Config\n",
+			md:       "## New\n\n# Intro\n\n## Usage\n\n## Config",
+			expected: "## New\n\n# Intro\n\n## Usage\n\n## Config",
+		},
+		{
+			name:     "explicit id",
+			previous: "# Intro {#start}\n\ntext\n",
+			md:       "# Intro\n\ntext",
+			expected: "# Intro {#start}\n\ntext",
+		},
+		{
+			name:     "setext and duplicates",
+			previous: "Notes\n=====\n\n## Notes\n",
+			md:       "# Notes\n\n## More notes",
+			expected: "# Notes\n\n## More notes {#notes-1}",
+		},
+		{
+			name:     "code blocks",
+			previous: "# Intro\n\n```sh\n# comment\n```\n",
+			md:       "# Introduction\n\n```sh\n# comment\n```",
+			expected: "# Introduction {#intro}\n\n```sh\n# comment\n```",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, preserveHeadingIDs(tc.md, tc.previous))
+		})
+	}
+}
+
+func TestLinkedAnchorWarnings(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "a.md"), []byte("[hello](/foo/test/#hello)\n"), 0644))
+
+	// Renamed headings keep their anchor
+	warnings, _, err := reviewUpdate("foo/test", "<h1>hello again</h1>")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	warnings, _, err = reviewUpdate("foo/test", "<p>no more headings</p>")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"The anchor #hello no longer exists, but is linked from /foo/a"}, warnings)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// siteConfig is the subset of the Hugo site config that determines where content lives and where it's published.
+type siteConfig struct {
+	BaseURL                 string
+	ContentDir              string
+	StaticDir               string
+	DefaultLanguage         string
+	DefaultLanguageInSubdir bool
+	Languages               []siteLanguage               // ordered by weight
+	Permalinks              map[string]map[string]string // page kind -> section -> pattern
+	Taxonomies              map[string]string            // singular -> plural
+	UglyURLs                bool
+	DisablePathToLower      bool
+	UnsafeHTML              bool // whether raw html in content is rendered (markup.goldmark.renderer.unsafe)
+	Markup                  markupConfig
+}
+
+// markupConfig is the rest of the Goldmark config (markup.goldmark) that changes how pages are rendered.
+type markupConfig struct {
+	Extensions    map[string]bool // enabled by their lowercased name e.g. tasklist
+	AutoHeadingID bool
+	Attributes    bool // {#id .class} after headings
+	HardWraps     bool
+	XHTML         bool
+}
+
+// Enabled by default in Hugo
+var markupExtensions = []string{"typographer", "footnote", "definitionlist", "table", "strikethrough", "linkify", "tasklist"}
+
+type siteLanguage struct {
+	Code       string
+	BaseURL    string
+	ContentDir string
+	Weight     int
+}
+
+var site = defaultSiteConfig()
+
+func defaultSiteConfig() siteConfig {
+	return siteConfig{
+		ContentDir:      "content",
+		StaticDir:       "static",
+		DefaultLanguage: "en",
+		Permalinks:      map[string]map[string]string{},
+		Taxonomies:      map[string]string{"tag": "tags", "category": "categories"},
+		Markup:          defaultMarkupConfig(),
+	}
+}
+
+func defaultMarkupConfig() markupConfig {
+	c := markupConfig{Extensions: map[string]bool{}, AutoHeadingID: true, Attributes: true}
+	for _, name := range markupExtensions {
+		c.Extensions[name] = true
+	}
+	return c
+}
+
+// Same precedence as Hugo
+var siteConfigFiles = []string{"hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json", "config.toml", "config.yaml", "config.yml", "config.json"}
+
+// loadSiteConfig reads the Hugo config from the root of the repo, falling back to Hugo's defaults if there isn't one.
+func loadSiteConfig() (siteConfig, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	for _, name := range siteConfigFiles {
+		raw, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return siteConfig{}, fmt.Errorf("reading %s: %w", name, err)
+		}
+
+		values := map[string]any{}
+		switch filepath.Ext(name) {
+		case ".toml":
+			err = toml.Unmarshal(raw, &values)
+		case ".json":
+			err = json.Unmarshal(raw, &values)
+		default:
+			err = yaml.Unmarshal(raw, &values)
+		}
+		if err != nil {
+			return siteConfig{}, fmt.Errorf("parsing %s: %w", name, err)
+		}
+
+		return parseSiteConfig(lowerKeys(values)), nil
+	}
+
+	return defaultSiteConfig(), nil
+}
+
+func parseSiteConfig(values map[string]any) siteConfig {
+	c := defaultSiteConfig()
+	c.BaseURL, _ = values["baseurl"].(string)
+	if dir, ok := values["contentdir"].(string); ok && dir != "" {
+		c.ContentDir = filepath.Clean(dir)
+	}
+	switch dir := values["staticdir"].(type) {
+	case string:
+		c.StaticDir = filepath.Clean(dir)
+	case []any: // only the first static dir is written to
+		if len(dir) > 0 {
+			if first, ok := dir[0].(string); ok {
+				c.StaticDir = filepath.Clean(first)
+			}
+		}
+	}
+	if lang, ok := values["defaultcontentlanguage"].(string); ok && lang != "" {
+		c.DefaultLanguage = strings.ToLower(lang)
+	}
+	c.DefaultLanguageInSubdir, _ = values["defaultcontentlanguageinsubdir"].(bool)
+	c.UglyURLs, _ = values["uglyurls"].(bool)
+	c.DisablePathToLower, _ = values["disablepathtolower"].(bool)
+
+	markup, _ := values["markup"].(map[string]any)
+	goldmark, _ := markup["goldmark"].(map[string]any)
+	renderer, _ := goldmark["renderer"].(map[string]any)
+	c.UnsafeHTML, _ = renderer["unsafe"].(bool)
+	c.Markup.HardWraps, _ = renderer["hardwraps"].(bool)
+	c.Markup.XHTML, _ = renderer["xhtml"].(bool)
+
+	parser, _ := goldmark["parser"].(map[string]any)
+	if enabled, ok := parser["autoheadingid"].(bool); ok {
+		c.Markup.AutoHeadingID = enabled
+	}
+	attribute, _ := parser["attribute"].(map[string]any)
+	if enabled, ok := attribute["title"].(bool); ok {
+		c.Markup.Attributes = enabled
+	}
+
+	extensions, _ := goldmark["extensions"].(map[string]any)
+	for _, name := range markupExtensions {
+		switch value := extensions[name].(type) {
+		case bool:
+			c.Markup.Extensions[name] = value
+		case map[string]any: // e.g. the typographer's settings
+			disabled, _ := value["disable"].(bool)
+			c.Markup.Extensions[name] = !disabled
+		}
+	}
+
+	if languages, ok := values["languages"].(map[string]any); ok {
+		for code, raw := range languages {
+			settings, _ := raw.(map[string]any)
+			lang := siteLanguage{Code: code, Weight: toInt(settings["weight"])}
+			lang.BaseURL, _ = settings["baseurl"].(string)
+			if dir, ok := settings["contentdir"].(string); ok && dir != "" {
+				lang.ContentDir = filepath.Clean(dir)
+			}
+			c.Languages = append(c.Languages, lang)
+		}
+		sort.Slice(c.Languages, func(i, j int) bool {
+			if c.Languages[i].Weight != c.Languages[j].Weight {
+				return c.Languages[i].Weight < c.Languages[j].Weight
+			}
+			return c.Languages[i].Code < c.Languages[j].Code
+		})
+	}
+
+	if permalinks, ok := values["permalinks"].(map[string]any); ok {
+		for key, value := range permalinks {
+			switch value := value.(type) {
+			case string: // the legacy format only configures regular pages
+				setNested(c.Permalinks, "page", key, value)
+			case map[string]any:
+				for section, pattern := range value {
+					if pattern, ok := pattern.(string); ok {
+						setNested(c.Permalinks, key, section, pattern)
+					}
+				}
+			}
+		}
+	}
+
+	if taxonomies, ok := values["taxonomies"].(map[string]any); ok {
+		c.Taxonomies = map[string]string{}
+		for singular, plural := range taxonomies {
+			if plural, ok := plural.(string); ok {
+				c.Taxonomies[singular] = plural
+			}
+		}
+	}
+
+	return c
+}
+
+func setNested(m map[string]map[string]string, key, subkey, value string) {
+	if m[key] == nil {
+		m[key] = map[string]string{}
+	}
+	m[key][subkey] = value
+}
+
+// contentRoots returns a content root for every distinct content dir of the site.
+// Content of the default language is edited without a prefix, other languages are prefixed by their code.
+func (c siteConfig) contentRoots() []contentRoot {
+	defaultDir := c.ContentDir
+	for _, lang := range c.Languages {
+		if lang.Code == c.DefaultLanguage && lang.ContentDir != "" {
+			defaultDir = lang.ContentDir
+		}
+	}
+
+	roots := []contentRoot{{Dir: defaultDir, Lang: c.DefaultLanguage}}
+	seen := map[string]bool{defaultDir: true}
+	for _, lang := range c.Languages {
+		if lang.ContentDir == "" || seen[lang.ContentDir] {
+			continue
+		}
+		seen[lang.ContentDir] = true
+		roots = append(roots, contentRoot{Prefix: lang.Code, Dir: lang.ContentDir, Lang: lang.Code})
+	}
+
+	sort.SliceStable(roots, func(i, j int) bool { return len(roots[i].Prefix) > len(roots[j].Prefix) })
+	return roots
+}
+
+// baseURL returns the base URL of the given language (or the default language if empty), without a trailing slash.
+func (c siteConfig) baseURL(lang string) string {
+	for _, l := range c.Languages {
+		if l.Code == lang && l.BaseURL != "" {
+			return strings.TrimSuffix(l.BaseURL, "/")
+		}
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+// basePath returns the path of the base URL without a trailing slash, which prefixes all URLs of the site.
+func (c siteConfig) basePath() string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// permalink returns the path a content file is published at, relative to the base URL.
+// rel is the file's slash separated path relative to its content dir.
+func (c siteConfig) permalink(lang, rel string, fm map[string]any) string {
+	if u, ok := fm["url"].(string); ok && u != "" {
+		return "/" + strings.TrimPrefix(u, "/")
+	}
+
+	dir := path.Dir(rel)
+	if dir == "." {
+		dir = ""
+	}
+	name := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+
+	kind := "page"
+	if name == "_index" {
+		kind = "section"
+		if dir == "" {
+			kind = "home"
+		}
+		for _, plural := range c.Taxonomies {
+			switch {
+			case dir == plural:
+				kind = "taxonomy"
+			case path.Dir(dir) == plural:
+				kind = "term"
+			}
+		}
+	}
+	if name == "index" { // leaf bundles are named by their dir
+		name = path.Base(dir)
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+	}
+
+	section := strings.Split(dir, "/")[0]
+	var p string
+	if pattern, ok := c.Permalinks[kind][section]; ok && kind != "home" {
+		p = c.expandPermalink(pattern, dir, section, name, fm)
+	} else {
+		switch kind {
+		case "home":
+			p = "/"
+		case "page":
+			slug, _ := fm["slug"].(string)
+			if slug == "" {
+				slug = name
+			}
+			p = "/" + path.Join(dir, slug) + "/"
+		default:
+			p = "/" + dir + "/"
+		}
+		p = c.sanitizePath(p)
+	}
+	if c.UglyURLs && kind == "page" && p != "/" && strings.HasSuffix(p, "/") {
+		p = strings.TrimSuffix(p, "/") + ".html"
+	}
+
+	if lang != "" && (lang != c.DefaultLanguage || c.DefaultLanguageInSubdir) {
+		p = "/" + lang + p
+	}
+	return p
+}
+
+var permalinkTokenRegex = regexp.MustCompile(`:[a-z]+`)
+
+func (c siteConfig) expandPermalink(pattern, dir, section, name string, fm map[string]any) string {
+	date := parseDate(fm["date"])
+	slug, _ := fm["slug"].(string)
+	title, _ := fm["title"].(string)
+	if title == "" {
+		title = name
+	}
+
+	return permalinkTokenRegex.ReplaceAllStringFunc(pattern, func(token string) string {
+		switch token {
+		case ":year":
+			return date.Format("2006")
+		case ":month":
+			return date.Format("01")
+		case ":monthname":
+			return c.sanitizePath(date.Format("January"))
+		case ":day":
+			return date.Format("02")
+		case ":weekday":
+			return fmt.Sprint(int(date.Weekday()))
+		case ":weekdayname":
+			return c.sanitizePath(date.Format("Monday"))
+		case ":yearday":
+			return fmt.Sprint(date.YearDay())
+		case ":section":
+			return c.sanitizePath(section)
+		case ":sections":
+			return c.sanitizePath(dir)
+		case ":title":
+			return urlize(title)
+		case ":slug":
+			if slug == "" {
+				return urlize(title)
+			}
+			return c.sanitizePath(slug)
+		case ":filename", ":contentbasename":
+			return c.sanitizePath(name)
+		case ":slugorfilename", ":slugorcontentbasename":
+			if slug == "" {
+				return c.sanitizePath(name)
+			}
+			return c.sanitizePath(slug)
+		default:
+			return token
+		}
+	})
+}
+
+var dateFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseDate(v any) time.Time {
+	var s string
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case toml.LocalDate:
+		return v.AsTime(time.UTC)
+	case toml.LocalDateTime:
+		return v.AsTime(time.UTC)
+	case string:
+		s = v
+	}
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// sanitizePath approximates Hugo's path sanitization: spaces become hyphens and paths are lowercased unless disabled.
+func (c siteConfig) sanitizePath(p string) string {
+	p = strings.ReplaceAll(p, " ", "-")
+	if !c.DisablePathToLower {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// urlize approximates Hugo's urlize function.
+func urlize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// liveURL returns the URL a page is published at, or false if it can't be determined.
+func liveURL(page string) (string, bool) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	file, found := resolvePage(page)
+	root, _, _ := rootOf(page)
+	base := site.baseURL(root.Lang)
+	if !found || base == "" {
+		return "", false
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root.Dir, file)
+	if err != nil {
+		return "", false
+	}
+
+	return base + site.permalink(root.Lang, filepath.ToSlash(rel), parseFrontmatter(string(raw))), true
+}
+
+// findPageByURL returns the page published at the given URL, taking permalinks, slugs, urls and aliases into account.
+// Pages are looked up in the cached page records, rather than reading every file.
+func findPageByURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	target := normalizeURLPath(u.Path)
+
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	records, err := loadPageRecords()
+	if err != nil {
+		slog.Error("error while resolving page url", "error", err)
+		return "", false
+	}
+
+	// Files are checked in order, so the same page wins if several claim the URL
+	files := make([]string, 0, len(records))
+	for file := range records {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		page := records[file]
+
+		// The base URL might include a path
+		candidates := []string{page.Permalink}
+		if base, err := url.Parse(site.baseURL(page.Root.Lang)); err == nil && base.Path != "" {
+			candidates = append(candidates, base.Path+page.Permalink)
+		}
+		candidates = append(candidates, page.Aliases...)
+
+		for _, candidate := range candidates {
+			if normalizeURLPath(candidate) == target {
+				return page.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func normalizeURLPath(p string) string {
+	p = "/" + strings.Trim(strings.TrimSuffix(p, "index.html"), "/")
+	if path.Ext(p) != ".html" && p != "/" {
+		p += "/"
+	}
+	return strings.ToLower(p)
+}
+
+// lowerKeys lowercases the keys of nested maps since Hugo's config keys are case insensitive.
+func lowerKeys(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for key, value := range m {
+		if nested, ok := value.(map[string]any); ok {
+			value = lowerKeys(nested)
+		}
+		out[strings.ToLower(key)] = value
+	}
+	return out
+}
+
+func toInt(v any) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
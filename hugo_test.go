@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteConfig(t *testing.T) {
+	var values map[string]any
+	require.NoError(t, toml.Unmarshal([]byte(`
+baseURL = "https://example.com/docs/"
+contentDir = "src"
+defaultContentLanguage = "en"
+
+[permalinks]
+posts = "/:year/:month/:slug/"
+[permalinks.section]
+posts = "/blog/"
+[permalinks.term]
+tags = "/topic/:slug/"
+
+[taxonomies]
+tag = "tags"
+
+[languages.en]
+weight = 1
+[languages.de]
+weight = 2
+contentDir = "src/de"
+baseURL = "https://example.de"
+
+[markup.goldmark.renderer]
+unsafe = true
+hardWraps = true
+[markup.goldmark.parser]
+autoHeadingID = false
+[markup.goldmark.extensions]
+linkify = false
+[markup.goldmark.extensions.typographer]
+disable = true
+`), &values))
+	c := parseSiteConfig(lowerKeys(values))
+
+	assert.Equal(t, []contentRoot{
+		{Prefix: "de", Dir: "src/de", Lang: "de"},
+		{Prefix: "", Dir: "src", Lang: "en"},
+	}, c.contentRoots())
+	assert.Equal(t, "https://example.com/docs", c.baseURL("en"))
+	assert.Equal(t, "https://example.de", c.baseURL("de"))
+	assert.True(t, c.UnsafeHTML)
+	assert.False(t, defaultSiteConfig().UnsafeHTML)
+	assert.True(t, c.Markup.HardWraps)
+	assert.False(t, c.Markup.AutoHeadingID)
+	assert.True(t, c.Markup.Attributes)
+	assert.False(t, c.Markup.Extensions["linkify"])
+	assert.False(t, c.Markup.Extensions["typographer"])
+	assert.True(t, c.Markup.Extensions["tasklist"])
+
+	for _, tc := range []struct {
+		lang, rel string
+		fm        map[string]any
+		expected  string
+	}{
+		{lang: "en", rel: "_index.md", expected: "/"},
+		{lang: "de", rel: "_index.md", expected: "/de/"},
+		{lang: "en", rel: "about.md", expected: "/about/"},
+		{lang: "en", rel: "about.md", fm: map[string]any{"slug": "Who We Are"}, expected: "/who-we-are/"},
+		{lang: "en", rel: "about.md", fm: map[string]any{"url": "custom/path/"}, expected: "/custom/path/"},
+		{lang: "en", rel: "Guides/Getting Started.md", expected: "/guides/getting-started/"},
+		{lang: "en", rel: "guides/bundle/index.md", expected: "/guides/bundle/"},
+		{lang: "en", rel: "guides/_index.md", expected: "/guides/"},
+		{lang: "en", rel: "posts/_index.md", expected: "/blog/"},
+		{lang: "en", rel: "posts/first.md", fm: map[string]any{"title": "Hello, World!", "date": "2024-03-05T10:00:00Z"}, expected: "/2024/03/hello-world/"},
+		{lang: "en", rel: "posts/first/index.md", fm: map[string]any{"slug": "intro", "date": "2024-03-05"}, expected: "/2024/03/intro/"},
+		{lang: "de", rel: "posts/first.md", fm: map[string]any{"slug": "erste", "date": "2024-03-05"}, expected: "/de/2024/03/erste/"},
+		{lang: "en", rel: "tags/_index.md", expected: "/tags/"},
+		{lang: "en", rel: "tags/go/_index.md", fm: map[string]any{"title": "Go"}, expected: "/topic/go/"},
+	} {
+		fm := tc.fm
+		if fm == nil {
+			fm = map[string]any{}
+		}
+		assert.Equal(t, tc.expected, c.permalink(tc.lang, tc.rel, fm), tc.rel)
+	}
+
+	c.UglyURLs = true
+	assert.Equal(t, "/about.html", c.permalink("en", "about.md", map[string]any{}))
+	assert.Equal(t, "/guides/", c.permalink("en", "guides/_index.md", map[string]any{}))
+	// Only the first static dir is written to, and an empty list keeps the default
+	assert.Equal(t, "assets", parseSiteConfig(map[string]any{"staticdir": []any{"assets", "static"}}).StaticDir)
+	assert.Equal(t, defaultSiteConfig().StaticDir, parseSiteConfig(map[string]any{"staticdir": []any{}}).StaticDir)
+}
+
+func TestLoadSiteConfig(t *testing.T) {
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	c, err := loadSiteConfig()
+	require.NoError(t, err)
+	assert.Equal(t, defaultSiteConfig(), c)
+
+	require.NoError(t, os.WriteFile("config.toml", []byte(`baseURL = "https://ignored.com"`), 0644))
+	require.NoError(t, os.WriteFile("hugo.yaml", []byte("baseURL: https://example.com\nContentDir: docs\ntaxonomies:\n  series: series\n"), 0644))
+
+	c, err = loadSiteConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", c.BaseURL)
+	assert.Equal(t, "docs", c.ContentDir)
+	assert.Equal(t, map[string]string{"series": "series"}, c.Taxonomies)
+}
+
+func TestLiveURL(t *testing.T) {
+	t.Cleanup(func() { site = defaultSiteConfig() })
+
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	_, ok := liveURL("foo/test")
+	assert.False(t, ok, "base URL is unknown")
+
+	site.BaseURL = "https://example.com/"
+	url, ok := liveURL("foo/test")
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/foo/test/", url)
+
+	_, ok = liveURL("foo/missing")
+	assert.False(t, ok)
+}
+
+func TestFindPageByURL(t *testing.T) {
+	t.Cleanup(func() { site = defaultSiteConfig() })
+	site.BaseURL = "https://example.com/wiki/"
+
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "foo", "bundle"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "index.md"), []byte("+++\nslug = \"Renamed\"\naliases = [\"/old/\", \"older\"]\n+++\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "_index.md"), []byte("home\n"), 0644))
+
+	for url, expected := range map[string]string{
+		"https://example.com/wiki/foo/test/":        "foo/test",
+		"https://example.com/wiki/foo/test":         "foo/test",
+		"/foo/test/index.html#heading":              "foo/test",
+		"https://example.com/wiki/foo/renamed/?q=1": "foo/bundle",
+		"https://example.com/old":                   "foo/bundle",
+		"https://example.com/foo/older/":            "foo/bundle",
+		"https://example.com/wiki/":                 "",
+		"https://example.com/wiki":                  "",
+	} {
+		page, found := findPageByURL(url)
+		assert.True(t, found, url)
+		assert.Equal(t, expected, page, url)
+	}
+
+	_, found := findPageByURL("https://example.com/wiki/foo/bundle/")
+	assert.False(t, found)
+
+	// Saved changes are taken into account
+	require.NoError(t, stageSourceUpdate("foo/test", "+++\naliases = [\"/moved/\"]\n+++\nbody\n", "user@test.com"))
+	page, found := findPageByURL("https://example.com/moved/")
+	assert.True(t, found)
+	assert.Equal(t, "foo/test", page)
+}
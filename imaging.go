@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+)
+
+var (
+	maxImageDimension       = 2048     // longest side in pixels, larger images are downscaled
+	maxFileSize       int64 = 10 << 20 // per file, before processing
+	maxImagePixels    int64 = 64 << 20 // decoded, across all frames of animated gifs
+)
+
+// processImage re-encodes an image to strip any metadata (GPS coordinates etc.), applying the EXIF orientation
+// and downscaling it to fit within maxImageDimension. Returns the new image and its content type.
+func processImage(buf []byte) ([]byte, string, error) {
+	if int64(len(buf)) > maxFileSize {
+		return nil, "", rejectf("image is %s, larger than the %s limit", formatSize(int64(len(buf))), formatSize(maxFileSize))
+	}
+
+	contentType := http.DetectContentType(buf)
+	if err := checkImageSize(buf, contentType); err != nil {
+		return nil, "", err
+	}
+
+	out := &bytes.Buffer{}
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return nil, "", rejectf("invalid jpeg: %s", err)
+		}
+		img = downscale(orient(img, exifOrientation(buf)), maxImageDimension)
+		if err := jpeg.Encode(out, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, "", fmt.Errorf("encoding jpeg: %w", err)
+		}
+
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return nil, "", rejectf("invalid png: %s", err)
+		}
+		if err := png.Encode(out, downscale(img, maxImageDimension)); err != nil {
+			return nil, "", fmt.Errorf("encoding png: %w", err)
+		}
+
+	case "image/gif":
+		anim, err := gif.DecodeAll(bytes.NewReader(buf))
+		if err != nil {
+			return nil, "", rejectf("invalid gif: %s", err)
+		}
+		if err := gif.EncodeAll(out, downscaleGIF(anim, maxImageDimension)); err != nil {
+			return nil, "", fmt.Errorf("encoding gif: %w", err)
+		}
+
+	default:
+		return nil, "", rejectf("unsupported image type %q, only png, jpeg and gif images can be uploaded", contentType)
+	}
+
+	return out.Bytes(), http.DetectContentType(out.Bytes()), nil
+}
+
+// checkImageSize rejects images that would take too much memory to decode. Small files can declare huge images
+// (decompression bombs), so only the header is decoded.
+func checkImageSize(buf []byte, contentType string) error {
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return rejectf("invalid image: %s", err)
+	}
+
+	pixels := int64(config.Width) * int64(config.Height)
+	if contentType == "image/gif" {
+		pixels *= int64(max(1, gifFrameCount(buf)))
+	}
+	if pixels > maxImagePixels {
+		return rejectf("image is %dx%d pixels, larger than the %d megapixel limit", config.Width, config.Height, maxImagePixels>>20)
+	}
+	return nil
+}
+
+// gifFrameCount counts the frames of a gif by walking its blocks, without decoding them.
+func gifFrameCount(buf []byte) int {
+	if len(buf) < 13 {
+		return 0
+	}
+	pos := 13 // header and logical screen descriptor
+	if buf[10]&0x80 != 0 {
+		pos += 3 << (buf[10]&7 + 1) // global color table
+	}
+	skipSubBlocks := func() {
+		for pos < len(buf) && buf[pos] != 0 {
+			pos += int(buf[pos]) + 1
+		}
+		pos++
+	}
+
+	frames := 0
+	for pos < len(buf) {
+		switch buf[pos] {
+		case 0x21: // extension
+			pos += 2
+			skipSubBlocks()
+		case 0x2c: // image descriptor
+			frames++
+			if pos+10 > len(buf) {
+				return frames
+			}
+			flags := buf[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1) // local color table
+			}
+			pos++ // minimum code size
+			skipSubBlocks()
+		default: // trailer
+			return frames
+		}
+	}
+	return frames
+}
+
+// scaledSize returns the size of an image of the given size after fitting it within maxDim.
+func scaledSize(w, h, maxDim int) (int, int, bool) {
+	if maxDim <= 0 || (w <= maxDim && h <= maxDim) {
+		return w, h, false
+	}
+	scale := float64(maxDim) / float64(max(w, h))
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale))), true
+}
+
+// downscale resizes the image to fit within maxDim by averaging the source pixels covered by each new pixel.
+func downscale(src image.Image, maxDim int) image.Image {
+	b := src.Bounds()
+	w, h, ok := scaledSize(b.Dx(), b.Dy(), maxDim)
+	if !ok {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+max((y+1)*b.Dy()/h, y*b.Dy()/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+max((x+1)*b.Dx()/w, x*b.Dx()/w+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(bl / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// downscaleGIF resizes every frame of an animation to fit within maxDim.
+// Frames are sampled rather than averaged to keep their palettes.
+func downscaleGIF(anim *gif.GIF, maxDim int) *gif.GIF {
+	w, h, ok := scaledSize(anim.Config.Width, anim.Config.Height, maxDim)
+	if !ok {
+		return anim
+	}
+	sx := float64(w) / float64(anim.Config.Width)
+	sy := float64(h) / float64(anim.Config.Height)
+
+	for i, frame := range anim.Image {
+		b := frame.Bounds()
+		rect := image.Rect(
+			int(float64(b.Min.X)*sx), int(float64(b.Min.Y)*sy),
+			min(int(math.Ceil(float64(b.Max.X)*sx)), w), min(int(math.Ceil(float64(b.Max.Y)*sy)), h),
+		)
+		scaled := image.NewPaletted(rect, frame.Palette)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				srcX := min(int(float64(x)/sx), b.Max.X-1)
+				srcY := min(int(float64(y)/sy), b.Max.Y-1)
+				scaled.SetColorIndex(x, y, frame.ColorIndexAt(max(srcX, b.Min.X), max(srcY, b.Min.Y)))
+			}
+		}
+		anim.Image[i] = scaled
+	}
+	anim.Config.Width, anim.Config.Height = w, h
+	return anim
+}
+
+// orient applies an EXIF orientation to the image, since the tag is lost when re-encoding.
+func orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 { // rotated by 90 degrees
+		w, h = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = b.Dx()-1-x, y
+			case 3: // rotated 180
+				dx, dy = b.Dx()-1-x, b.Dy()-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, b.Dy()-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = b.Dy()-1-y, x
+			case 7: // transversed
+				dx, dy = b.Dy()-1-y, b.Dx()-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, b.Dx()-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the orientation tag of a jpeg's EXIF metadata, or 0 if it doesn't have one.
+func exifOrientation(buf []byte) int {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return 0
+	}
+
+	// Find the APP1 segment
+	for i := 2; i+4 <= len(buf) && buf[i] == 0xFF; {
+		marker := buf[i+1]
+		size := int(binary.BigEndian.Uint16(buf[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(buf) { // start of scan
+			return 0
+		}
+		segment := buf[i+4 : i+2+size]
+		i += 2 + size
+
+		if marker != 0xE1 || !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			continue
+		}
+		tiff := segment[6:]
+		if len(tiff) < 8 {
+			return 0
+		}
+
+		var order binary.ByteOrder = binary.BigEndian
+		if string(tiff[:2]) == "II" {
+			order = binary.LittleEndian
+		}
+
+		// Walk the entries of the first IFD
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd+2 > len(tiff) {
+			return 0
+		}
+		count := int(order.Uint16(tiff[ifd:]))
+		for j := 0; j < count; j++ {
+			entry := ifd + 2 + j*12
+			if entry+12 > len(tiff) {
+				return 0
+			}
+			if order.Uint16(tiff[entry:]) == 0x0112 {
+				return int(order.Uint16(tiff[entry+8:]))
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessImageDownscale(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 3000, 1000))))
+
+	out, contentType, err := processImage(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, 2048, cfg.Width)
+	assert.Equal(t, 683, cfg.Height)
+}
+
+func TestProcessImageAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.White)
+		src.Set(x, 1, color.Black)
+	}
+
+	dst := downscale(src, 2)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), dst.Bounds())
+	assert.Equal(t, color.RGBA{R: 127, G: 127, B: 127, A: 255}, dst.At(0, 0))
+}
+
+func TestProcessImageStripsMetadata(t *testing.T) {
+	// A 4x2 jpeg with a white left column, rotated 90 degrees clockwise by its EXIF orientation
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			src.Set(x, y, color.Black)
+		}
+		src.Set(0, y, color.White)
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(buf, src, &jpeg.Options{Quality: 100}))
+	img := withEXIF(buf.Bytes(), 6)
+	require.Equal(t, 6, exifOrientation(img))
+
+	out, contentType, err := processImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.NotContains(t, string(out), "Exif")
+	assert.NotContains(t, string(out), "GPS")
+
+	decoded, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), decoded.Bounds())
+
+	// The white column is now the top row
+	r, _, _, _ := decoded.At(1, 0).RGBA()
+	assert.Greater(t, r, uint32(0xC000))
+	r, _, _, _ = decoded.At(1, 3).RGBA()
+	assert.Less(t, r, uint32(0x4000))
+}
+
+func TestProcessImageAnimatedGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{
+		Image:     []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4096, 10), palette), image.NewPaletted(image.Rect(2048, 0, 4096, 10), palette)},
+		Delay:     []int{10, 10},
+		LoopCount: 0,
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, gif.EncodeAll(buf, anim))
+
+	out, contentType, err := processImage(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "image/gif", contentType)
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(out))
+	require.NoError(t, err)
+	require.Len(t, decoded.Image, 2)
+	assert.Equal(t, 2048, decoded.Config.Width)
+	assert.Equal(t, 5, decoded.Config.Height)
+	assert.Equal(t, image.Rect(1024, 0, 2048, 5), decoded.Image[1].Bounds())
+}
+
+func TestProcessImageLimits(t *testing.T) {
+	var rejected *userError
+
+	_, _, err := processImage([]byte("not an image"))
+	assert.True(t, errors.As(err, &rejected))
+
+	original := maxFileSize
+	t.Cleanup(func() { maxFileSize = original })
+	maxFileSize = 10
+
+	img, _ := testPNG(t)
+	_, _, err = processImage(img)
+	require.True(t, errors.As(err, &rejected))
+	assert.Contains(t, err.Error(), "larger than the 10 bytes limit")
+}
+
+func TestProcessImageBombs(t *testing.T) {
+	var rejected *userError
+
+	// A tiny png that declares a 100000x100000 image
+	img, _ := testPNG(t)
+	ihdr := img[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:], 100000)
+	binary.BigEndian.PutUint32(img[8+8+13:], crc32.ChecksumIEEE(img[8+4:8+8+13]))
+	_, _, err := processImage(img)
+	require.True(t, errors.As(err, &rejected))
+	assert.Equal(t, "image is 100000x100000 pixels, larger than the 64 megapixel limit", err.Error())
+
+	// Every frame of animated gifs counts
+	original := maxImagePixels
+	t.Cleanup(func() { maxImagePixels = original })
+	maxImagePixels = 100
+
+	animation := func(frames int) []byte {
+		anim := &gif.GIF{}
+		for i := 0; i < frames; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 5, 5), color.Palette{color.Black, color.White}))
+			anim.Delay = append(anim.Delay, 10)
+		}
+		buf := &bytes.Buffer{}
+		require.NoError(t, gif.EncodeAll(buf, anim))
+		return buf.Bytes()
+	}
+	assert.Equal(t, 5, gifFrameCount(animation(5)))
+
+	_, _, err = processImage(animation(3))
+	require.NoError(t, err)
+	_, _, err = processImage(animation(5))
+	require.True(t, errors.As(err, &rejected))
+}
+
+// withEXIF inserts an EXIF segment with the given orientation (and a fake GPS tag) after the SOI marker of a jpeg.
+func withEXIF(img []byte, orientation uint16) []byte {
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("MM")
+	binary.Write(tiff, binary.BigEndian, uint16(42))
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, uint32(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPS")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := append([]byte{0xFF, 0xD8, 0xFF, 0xE1}, byte((len(segment)+2)>>8), byte(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, img[2:]...)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// repoLayout describes where the site's content lives and how the repo is synced.
+type repoLayout struct {
+	Remote     string // name of the git remote
+	Branch     string
+	Roots      []contentRoot
+	Extensions []string // markdown file extensions, in order of preference
+}
+
+// contentRoot maps a URL prefix (relative to /edit/) to a directory of content.
+type contentRoot struct {
+	Prefix string // without leading or trailing slashes
+	Dir    string
+	Lang   string // language of the content, if known
+}
+
+var layout = repoLayout{
+	Remote:     "origin",
+	Branch:     "main",
+	Roots:      []contentRoot{{Dir: "content"}},
+	Extensions: []string{".md"},
+}
+
+// parseContentRoots parses a comma separated list of content dirs, optionally prefixed by a URL path e.g. "content,api=docs/api".
+func parseContentRoots(value string) ([]contentRoot, error) {
+	var roots []contentRoot
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		root := contentRoot{Dir: item}
+		if prefix, dir, ok := strings.Cut(item, "="); ok {
+			root = contentRoot{Prefix: strings.Trim(prefix, "/"), Dir: dir}
+		}
+		root.Dir = filepath.Clean(root.Dir)
+
+		for _, existing := range roots {
+			if existing.Prefix == root.Prefix {
+				return nil, fmt.Errorf("multiple content roots use the prefix %q", "/"+root.Prefix)
+			}
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("at least one content root is required")
+	}
+
+	// Most specific prefix first
+	sort.SliceStable(roots, func(i, j int) bool { return len(roots[i].Prefix) > len(roots[j].Prefix) })
+	return roots, nil
+}
+
+// parseExtensions parses a comma separated list of file extensions, with or without the leading dot.
+func parseExtensions(value string) []string {
+	var exts []string
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		exts = append(exts, "."+strings.TrimPrefix(ext, "."))
+	}
+	return exts
+}
+
+// rootOf returns the content root that serves the given page, and the page's path relative to it.
+func rootOf(page string) (contentRoot, string, bool) {
+	page = strings.Trim(page, "/")
+	for _, root := range layout.Roots {
+		if root.Prefix == "" {
+			return root, page, true
+		}
+		if page == root.Prefix {
+			return root, "", true
+		}
+		if rel, ok := strings.CutPrefix(page, root.Prefix+"/"); ok {
+			return root, rel, true
+		}
+	}
+	return contentRoot{}, "", false
+}
+
+// rootOfFile returns the content root holding a file (relative to the repo), and the file's path relative to it.
+func rootOfFile(file string) (contentRoot, string, bool) {
+	var match contentRoot
+	var matchRel string
+	found := false
+	for _, root := range layout.Roots {
+		rel, err := filepath.Rel(root.Dir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(root.Dir) > len(match.Dir) { // nested roots take precedence
+			match, matchRel, found = root, rel, true
+		}
+	}
+	return match, matchRel, found
+}
+
+// resolvePage maps a page name to its markdown file using Hugo's rules: a regular page (foo/bar.md),
+// a leaf bundle (foo/bar/index.md), or a section page (foo/bar/_index.md). The caller must hold gitLock.
+func resolvePage(page string) (string, bool) {
+	root, rel, ok := rootOf(page)
+	if !ok {
+		return "", false
+	}
+	if name := path.Base(rel); name == "index" || name == "_index" {
+		return "", false // bundles are addressed by their directory
+	}
+
+	base := filepath.Join(root.Dir, filepath.FromSlash(rel))
+	var candidates []string
+	if rel != "" {
+		for _, ext := range layout.Extensions {
+			candidates = append(candidates, base+ext)
+		}
+		candidates = append(candidates, indexFiles(base, "index")...)
+	}
+	candidates = append(candidates, indexFiles(base, "_index")...)
+
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func indexFiles(dir, name string) []string {
+	var files []string
+	for _, ext := range layout.Extensions {
+		files = append(files, filepath.Join(dir, name+ext))
+	}
+	return files
+}
+
+// findIndexFile returns the first existing index.* or _index.* file in dir.
+func findIndexFile(dir, name string) (string, bool) {
+	for _, path := range indexFiles(dir, name) {
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// isMarkdown returns true if the file has one of the configured markdown extensions.
+func isMarkdown(path string) bool {
+	return slices.Contains(layout.Extensions, filepath.Ext(path))
+}
+
+// isBundle returns true if the markdown file is the content of a leaf or branch bundle.
+func isBundle(path string) bool {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return isMarkdown(path) && (name == "index" || name == "_index")
+}
+
+// bundleDir returns the directory holding the resources of a bundled page.
+func bundleDir(page string) (string, bool) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	path, found := resolvePage(page)
+	if !found || !isBundle(path) {
+		return "", false
+	}
+	return filepath.Dir(path), true
+}
+
+// editURL returns the absolute URL of a page's editor, which is also correct for bundled pages whose editor sets a
+// base URL.
+func editURL(page string) string {
+	return (&url.URL{Path: "/edit/" + page}).String()
+}
+
+// pageName returns the name of the page stored in the given file, relative to its content root.
+func pageName(root contentRoot, rel string) string {
+	rel = filepath.ToSlash(rel)
+	name := strings.TrimSuffix(rel, path.Ext(rel))
+	if base := path.Base(name); base == "index" || base == "_index" {
+		name = path.Dir(name)
+		if name == "." {
+			name = ""
+		}
+	}
+	return path.Join(root.Prefix, name)
+}
+
+// walkPages calls fn for every markdown file in every content root. The caller must hold gitLock.
+func walkPages(fn func(root contentRoot, rel, file string) error) error {
+	for _, root := range layout.Roots {
+		err := filepath.WalkDir(root.Dir, func(file string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				for _, other := range layout.Roots {
+					if other.Dir != root.Dir && other.Dir == file {
+						return filepath.SkipDir // nested content roots are walked separately
+					}
+				}
+				return nil
+			}
+			if !isMarkdown(file) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root.Dir, file)
+			if err != nil {
+				return err
+			}
+			return fn(root, rel, file)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("walking %s: %w", root.Dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentRoots(t *testing.T) {
+	roots, err := parseContentRoots("content, api=docs/api/,/blog/=posts")
+	require.NoError(t, err)
+	assert.Equal(t, []contentRoot{
+		{Prefix: "blog", Dir: "posts"},
+		{Prefix: "api", Dir: filepath.Join("docs", "api")},
+		{Prefix: "", Dir: "content"},
+	}, roots)
+
+	_, err = parseContentRoots("content,docs")
+	assert.Error(t, err)
+
+	_, err = parseContentRoots("")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{".md", ".markdown"}, parseExtensions("md, .markdown,"))
+}
+
+func TestCustomLayout(t *testing.T) {
+	original := layout
+	t.Cleanup(func() { layout = original })
+
+	roots, err := parseContentRoots("docs,api=reference")
+	require.NoError(t, err)
+	layout = repoLayout{Remote: "upstream", Branch: "gh-pages", Roots: roots, Extensions: []string{".markdown", ".md"}}
+
+	// Create a remote that only has the custom branch
+	remote := t.TempDir()
+	require.NoError(t, os.Chdir(remote))
+	require.NoError(t, git("init", "--bare"))
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, git("clone", remote, "."))
+	require.NoError(t, git("checkout", "-b", "gh-pages"))
+	require.NoError(t, os.MkdirAll("docs", 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join("reference", "v1"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("docs", "guide.markdown"), []byte("guide\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("reference", "v1", "_index.md"), []byte("v1\n"), 0644))
+	require.NoError(t, git("add", "."))
+	require.NoError(t, git("commit", "-m", "initial commit"))
+	require.NoError(t, git("push", "origin", "gh-pages"))
+
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	content, found, err := readPage("guide")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "<p>guide</p>\n", content)
+
+	content, found, err = readPage("api/v1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "<p>v1</p>\n", content)
+
+	_, found, err = readPage("v1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, stageUpdate("api/v1", "<p>v1 updated</p>", "user@test.com"))
+	require.NoError(t, pushPull())
+
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, git("clone", "--branch", "gh-pages", remote, "."))
+	raw, err := os.ReadFile(filepath.Join("reference", "v1", "_index.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1 updated\n", string(raw))
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Matches the arguments of a shortcode (without its delimiters), named or positional, quoted or not
+var shortcodeArgRegex = regexp.MustCompile("(?:[\\w-]+=)?(?:\"((?:[^\"\\\\]|\\\\.)*)\"|`([^`]*)`|([^\\s\"`=]+))")
+
+var mediaTempl = template.Must(template.New("").Parse(`
+<h1>Media</h1>
+{{- if .editing }}
+<p>Insert an existing file into <a href="/edit/{{ .page | html }}">/{{ .page | html }}</a> instead of uploading it again.</p>
+{{- end }}
+
+<input id="filter" type="search" placeholder="Filter by name or page" />
+
+<div id="assets">
+{{- range .assets }}
+    <div class="asset" data-search="{{ .Name | html }} {{ range .Pages }}{{ . | html }} {{ end }}">
+        <a class="thumb" href="{{ .URL | html }}" target="_blank">
+        {{- if .Image }}<img src="{{ .URL | html }}" loading="lazy" />{{ else }}<span>{{ .Ext | html }}</span>{{ end -}}
+        </a>
+        <div class="name" title="{{ .File | html }}">{{ .Name | html }}</div>
+        <div class="size">{{ .Size }}</div>
+        <ul class="pages">
+        {{- range .Pages }}
+            <li><a href="/edit/{{ . | html }}">/{{ . | html }}</a></li>
+        {{- else }}
+            <li class="unused">Not referenced by any page</li>
+        {{- end }}
+        </ul>
+    {{- if and $.editing .Src }}
+        <button class="insert" data-src="{{ .Src | html }}" data-name="{{ .Name | html }}" data-image="{{ .Image }}">Insert</button>
+    {{- else if $.editing }}
+        <button class="insert" disabled title="Resources of other page bundles can't be referenced">Insert</button>
+    {{- end }}
+    </div>
+{{- else }}
+    <p>No images or attachments have been uploaded yet.</p>
+{{- end }}
+</div>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    #filter {
+        padding: 6px;
+        width: 300px;
+        margin-bottom: 15px;
+    }
+
+    #assets {
+        display: grid;
+        grid-template-columns: repeat(auto-fill, minmax(200px, 1fr));
+        gap: 15px;
+    }
+
+    .asset {
+        border: 1px solid #ddd;
+        border-radius: 3px;
+        padding: 10px;
+        font-size: 90%;
+    }
+
+    .thumb {
+        display: flex;
+        align-items: center;
+        justify-content: center;
+        height: 120px;
+        background: #f5f5f5;
+        color: #777;
+        text-decoration: none;
+        text-transform: uppercase;
+    }
+
+    .thumb img {
+        max-width: 100%;
+        max-height: 100%;
+    }
+
+    .name {
+        margin-top: 6px;
+        word-break: break-all;
+    }
+
+    .size, .unused {
+        color: #777;
+    }
+
+    .pages {
+        padding-left: 20px;
+    }
+
+    .insert {
+        border: 1px solid #000;
+        padding: 4px;
+        border-radius: 3px;
+        background: transparent;
+        cursor: pointer;
+    }
+</style>
+
+<script>
+    document.getElementById('filter').addEventListener('input', (event) => {
+        const query = event.target.value.toLowerCase()
+        document.querySelectorAll('.asset').forEach((asset) => {
+            asset.hidden = !asset.dataset.search.toLowerCase().includes(query)
+        })
+    })
+
+    // The editor that opened this window inserts the asset
+    document.querySelectorAll('.insert:not([disabled])').forEach((button) => {
+        button.addEventListener('click', () => {
+            if (!window.opener) {
+                alert('Open the media library from the editor to insert files')
+                return
+            }
+            const { src, name, image } = button.dataset
+            window.opener.postMessage({ type: 'insert-media', src, name, image: image === 'true' }, location.origin)
+            window.close()
+        })
+    })
+</script>
+`))
+
+// mediaAsset is an image or attachment stored in the static dir or a page bundle.
+type mediaAsset struct {
+	File  string // path in the repo
+	Name  string
+	Ext   string
+	URL   string // where the editor serves the file
+	Src   string // how the current page can reference the file, if it can
+	Size  string
+	Image bool
+	Pages []string // pages that reference the file
+}
+
+var mediaImageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".avif"}
+
+// isMediaFile returns true for images and files that could have been attached.
+func isMediaFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	_, attachment := attachmentTypes[ext]
+	return attachment || slices.Contains(mediaImageExtensions, ext)
+}
+
+// listMedia returns every image and attachment in the repo along with the pages that reference them.
+// Assets are given a Src if the given page (if any) can reference them.
+func listMedia(page string) ([]*mediaAsset, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+	return readMedia(page)
+}
+
+// readMedia is listMedia for callers that hold gitLock.
+func readMedia(page string) ([]*mediaAsset, error) {
+	var pageDir string
+	if pageFile, found := resolvePage(page); found && isBundle(pageFile) {
+		pageDir = filepath.Dir(pageFile)
+	}
+
+	var assets []*mediaAsset
+	add := func(file, url, src string) error {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("reading file info: %w", err)
+		}
+		assets = append(assets, &mediaAsset{
+			File:  file,
+			Name:  filepath.Base(file),
+			Ext:   strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), "."),
+			URL:   url,
+			Src:   src,
+			Size:  formatSize(info.Size()),
+			Image: slices.Contains(mediaImageExtensions, strings.ToLower(filepath.Ext(file))),
+		})
+		return nil
+	}
+
+	// Static files can be referenced from any page
+	err := filepath.WalkDir(site.StaticDir, func(file string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isMediaFile(file) {
+			return err
+		}
+		rel, err := filepath.Rel(site.StaticDir, file)
+		if err != nil {
+			return err
+		}
+		url := site.basePath() + "/" + filepath.ToSlash(rel)
+		return add(file, url, url)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("walking %s: %w", site.StaticDir, err)
+	}
+
+	// Bundle resources can only be referenced from their own page, and are found while collecting references
+	refs := map[string][]string{}
+	err = walkPages(func(root contentRoot, rel, file string) error {
+		name := pageName(root, rel)
+
+		if isBundle(file) {
+			entries, err := os.ReadDir(filepath.Dir(file))
+			if err != nil {
+				return fmt.Errorf("reading bundle: %w", err)
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || !isMediaFile(entry.Name()) {
+					continue
+				}
+				var src string
+				if filepath.Dir(file) == pageDir {
+					src = entry.Name()
+				}
+				err := add(filepath.Join(filepath.Dir(file), entry.Name()), path.Join("/resources", name, entry.Name()), src)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading page: %w", err)
+		}
+		html := mdToHTML(removeFrontmatter(string(raw)))
+		files := append(referencedFiles(html, file), frontmatterFiles(string(raw), file)...)
+		for _, ref := range append(files, shortcodeFiles(string(raw), file)...) {
+			if !slices.Contains(refs[ref], name) {
+				refs[ref] = append(refs[ref], name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, asset := range assets {
+		asset.Pages = refs[asset.File]
+		sort.Strings(asset.Pages)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].File < assets[j].File })
+	return assets, nil
+}
+
+// frontmatterFiles returns the files referenced by any front matter value of the page stored in pageFile
+// e.g. images = ["cover.jpg"] or featured_image = "/images/foo.png".
+func frontmatterFiles(doc, pageFile string) []string {
+	var files []string
+	var visit func(value any)
+	visit = func(value any) {
+		switch value := value.(type) {
+		case string:
+			if file, ok := valueFile(pageFile, value); ok {
+				files = append(files, file)
+			}
+		case []any:
+			for _, item := range value {
+				visit(item)
+			}
+		case map[string]any:
+			for _, item := range value {
+				visit(item)
+			}
+		}
+	}
+	visit(parseFrontmatter(doc))
+	return files
+}
+
+// shortcodeFiles returns the files referenced by shortcode arguments of the page stored in pageFile
+// e.g. {{< figure src="cover.jpg" >}}, which are resolved like front matter values.
+func shortcodeFiles(doc, pageFile string) []string {
+	var files []string
+	for _, r := range findProtected(doc) {
+		for _, shortcode := range shortcodeRegex.FindAllString(doc[r.Start:r.End], -1) {
+			for _, match := range shortcodeArgRegex.FindAllStringSubmatch(shortcode[3:len(shortcode)-3], -1) {
+				if file, ok := valueFile(pageFile, match[1]+match[2]+match[3]); ok {
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// valueFile maps a front matter or shortcode argument value to the file it refers to. Values without a leading
+// slash are also looked up in the static dir, since that's how themes commonly use them.
+func valueFile(pageFile, value string) (string, bool) {
+	if file, ok := assetFile(pageFile, value); ok {
+		return file, true
+	}
+	if strings.HasPrefix(value, "/") {
+		return "", false
+	}
+	return assetFile(pageFile, site.basePath()+"/"+value)
+}
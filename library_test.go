@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMedia(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "foo", "bundle"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "index.md"), []byte("![](chart.png) [data](data.csv)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "data.csv"), []byte("a,b\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "notes.txt~"), []byte("ignored"), 0644))
+
+	img, name := testPNG(t)
+	src, err := storeUpload("foo/test", img)
+	require.NoError(t, err)
+	require.NoError(t, stageUpdate("foo/test", `<p><img src="`+src+`"></p>`, "user@test.com"))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "other.md"), []byte(`<img src="`+src+`">`), 0644))
+
+	assets, err := listMedia("foo/bundle")
+	require.NoError(t, err)
+	require.Len(t, assets, 2)
+
+	assert.Equal(t, &mediaAsset{
+		File:  filepath.Join("content", "foo", "bundle", "data.csv"),
+		Name:  "data.csv",
+		Ext:   "csv",
+		URL:   "/resources/foo/bundle/data.csv",
+		Src:   "data.csv",
+		Size:  "4 bytes",
+		Pages: []string{"foo/bundle"},
+	}, assets[0])
+
+	assert.Equal(t, filepath.Join("static", "images", name), assets[1].File)
+	assert.Equal(t, "/images/"+name, assets[1].URL)
+	assert.Equal(t, "/images/"+name, assets[1].Src)
+	assert.True(t, assets[1].Image)
+	assert.Equal(t, []string{"foo/other", "foo/test"}, assets[1].Pages)
+
+	// Bundle resources can't be inserted into other pages
+	assets, err = listMedia("foo/test")
+	require.NoError(t, err)
+	assert.Empty(t, assets[0].Src)
+}
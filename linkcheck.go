@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var linksTempl = template.Must(template.New("").Parse(`
+{{- if .report.Running }}
+<meta http-equiv="refresh" content="3" />
+{{- end }}
+<form method="post">
+    <h1>Broken links</h1>
+{{- if .report.Running }}
+    <p>Checking links, started {{ .report.Started.Format "2006-01-02 15:04:05 MST" }}...</p>
+{{- end }}
+{{- if .report.Checked.IsZero }}
+    <p>Links haven't been checked yet.</p>
+{{- else }}
+    <p>Checked {{ .report.Checked.Format "2006-01-02 15:04:05 MST" }}.</p>
+{{- end }}
+{{- if .report.Err }}
+    <div id="error-banner">The last check failed: {{ .report.Err | html }}</div>
+{{- end }}
+    <button id="save" type="submit"{{ if .report.Running }} disabled{{ end }}>Check Now</button>
+
+{{- range .report.Pages }}
+    <h3><a href="/edit/{{ .Page | html }}">/{{ .Page | html }}</a></h3>
+    <ul>
+    {{- range .Links }}
+        <li><code>{{ .Target | html }}</code>: {{ .Reason | html }}</li>
+    {{- end }}
+    </ul>
+{{- else }}
+{{- if not .report.Checked.IsZero }}
+    <p>No broken links were found.</p>
+{{- end }}
+{{- end }}
+</form>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    #save {
+        border: 1px solid #000;
+        padding: 6px;
+        border-radius: 3px;
+        background: transparent;
+        font-size: 100%;
+        cursor: pointer;
+    }
+
+    #error-banner {
+        padding: 15px;
+        background: #ffd6d6;
+        margin: 15px;
+    }
+</style>
+`))
+
+var (
+	linkReportMu sync.Mutex
+	linkReport   = &brokenLinkReport{}
+)
+
+// brokenLink is a link, image or ref that doesn't resolve to a page, anchor or file.
+type brokenLink struct {
+	Target string
+	Reason string
+}
+
+// brokenLinkReport is the result of the latest check of the links of every page.
+type brokenLinkReport struct {
+	Started time.Time
+	Checked time.Time
+	Running bool
+	Err     string
+	Pages   []pageLinks
+}
+
+type pageLinks struct {
+	Page  string
+	Links []brokenLink
+}
+
+// checkPageLinks returns the broken links of a page.
+func checkPageLinks(idx *linkIndex, page *pageRecord) []brokenLink {
+	if self := idx.pages[page.Name]; self != nil {
+		self.Anchors = page.Anchors // the page might be being edited
+	}
+
+	var broken []brokenLink
+	for _, link := range page.Links {
+		if _, reason := idx.resolve(page, link); reason != "" {
+			broken = append(broken, brokenLink{Target: link.Target, Reason: reason})
+		}
+	}
+	return broken
+}
+
+// checkLinks checks the links of every page, returning the pages with broken links.
+func checkLinks() ([]pageLinks, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	records, err := loadPageRecords()
+	if err != nil {
+		return nil, err
+	}
+	idx := newLinkIndex(records)
+
+	var pages []pageLinks
+	for _, page := range records {
+		if broken := checkPageLinks(idx, page); len(broken) > 0 {
+			pages = append(pages, pageLinks{Page: page.Name, Links: broken})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Page < pages[j].Page })
+	return pages, nil
+}
+
+// startLinkCheck checks every page's links in the background unless they're already being checked.
+func startLinkCheck() {
+	linkReportMu.Lock()
+	defer linkReportMu.Unlock()
+	if linkReport.Running {
+		return
+	}
+
+	report := *linkReport
+	report.Running = true
+	report.Started = time.Now()
+	linkReport = &report
+	go runLinkCheck()
+}
+
+// runLinkCheck checks every page's links and stores the result for the report page.
+func runLinkCheck() {
+	pages, err := checkLinks()
+	if err != nil {
+		slog.Error("error while checking links", "error", err)
+	} else {
+		slog.Info("checked links", "pagesWithBrokenLinks", len(pages), "latencyMS", time.Since(latestLinkReport().Started).Milliseconds())
+	}
+
+	linkReportMu.Lock()
+	defer linkReportMu.Unlock()
+	report := *linkReport
+	report.Checked = time.Now()
+	report.Running = false
+	report.Pages = pages
+	report.Err = ""
+	if err != nil {
+		report.Err = err.Error()
+	}
+	linkReport = &report
+}
+
+func latestLinkReport() *brokenLinkReport {
+	linkReportMu.Lock()
+	defer linkReportMu.Unlock()
+	return linkReport
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLinks(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "guides", "setup"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join("static", "images"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("static", "images", "logo.png"), []byte("png"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "guides", "setup", "diagram.png"), []byte("png"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "guides", "setup", "index.md"), []byte("+++\ntags = [\"Getting Started\"]\n+++\n## Install\n\n![](diagram.png) ![](missing.png) [top](#install) [bottom](#nope)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "guides", "faq.md"), []byte(`
+[setup](../setup/#install)
+[setup anchor](/guides/setup/#uninstall)
+[section](/guides/)
+[tag](/tags/getting-started/)
+[logo](/images/logo.png)
+[external](https://example.com/missing)
+[mail](mailto:someone@example.com)
+[gone](/guides/gone/)
+[ref]({{< ref "setup" >}})
+[ref anchor]({{< ref "/guides/setup/index.md#install" >}})
+[bad ref]({{< relref "/guides/gone.md" >}})
+`), 0644))
+
+	pages, err := checkLinks()
+	require.NoError(t, err)
+	assert.Equal(t, []pageLinks{
+		{Page: "guides/faq", Links: []brokenLink{
+			{Target: "/guides/setup/#uninstall", Reason: "anchor #uninstall not found on /guides/setup"},
+			{Target: "/guides/gone/", Reason: "page not found"},
+			{Target: `{{< relref "/guides/gone.md" >}}`, Reason: "page not found"},
+		}},
+		{Page: "guides/setup", Links: []brokenLink{
+			{Target: "missing.png", Reason: "image not found"},
+			{Target: "#nope", Reason: "anchor #nope not found"},
+		}},
+	}, pages)
+
+	// Checks started from the report page run in the background
+	startLinkCheck()
+	require.Eventually(t, func() bool { return !latestLinkReport().Running }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, pages, latestLinkReport().Pages)
+	assert.False(t, latestLinkReport().Checked.IsZero())
+
+	// Updates with broken links need to be confirmed
+	warnings, _, err := reviewUpdate("foo/test", `<p><a href="/guides/faq/">faq</a> <a href="/guides/nope/">nope</a></p>`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Broken link to /guides/nope/: page not found"}, warnings)
+
+	warnings, _, err = reviewUpdate("foo/test", `<h1>hello</h1><h2>Usage</h2><p>[[faq]] <a href="#usage">usage</a></p>`)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	// Links that were already broken aren't reported again
+	warnings, _, err = reviewUpdate("guides/faq", `<p><a href="/guides/gone/">gone</a> <a href="/guides/setup/#uninstall">setup</a> <a href="/guides/nope/">nope</a></p>`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Broken link to /guides/nope/: page not found"}, warnings)
+
+	warnings, err = reviewSourceUpdate("guides/setup", "## Usage\n\n[bottom](#nope) [top](#install)\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Broken link to #install: anchor #install not found",
+		"The anchor #install no longer exists, but is linked from /guides/faq",
+	}, warnings)
+}
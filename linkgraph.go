@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+var orphanPagesTempl = template.Must(template.New("").Parse(`
+<h1>Orphan pages</h1>
+<p>
+These pages aren't linked from any other page or menu, so readers can only find them through the site's section listings or search.
+</p>
+
+<ul>
+{{- range .pages }}
+    <li><a href="/edit/{{ .Name | html }}">{{ .Title | html }}</a> <span class="name">/{{ .Name | html }}</span></li>
+{{- else }}
+    <li>Every page is linked from somewhere.</li>
+{{- end }}
+</ul>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    .name {
+        font-size: 80%;
+        color: #777;
+    }
+</style>
+`))
+
+var (
+	refArgRegex = regexp.MustCompile(`\{\{[<%]\s*(ref|relref)\s+"([^"]+)"\s*[>%]\}\}`)
+	htmlIDRegex = regexp.MustCompile(`\sid="([^"]+)"`)
+)
+
+// pageRecord is what the link graph knows about a page, parsed from its file.
+type pageRecord struct {
+	Root      contentRoot
+	Rel       string // slash separated path of the file, relative to the content root
+	File      string
+	Name      string
+	Title     string
+	Permalink string
+	Aliases   []string
+	Terms     map[string][]string // taxonomy terms by plural
+	InMenu    bool
+	Anchors   map[string]bool
+	Links     []pageLink
+	Resources []string // files of the page's bundle
+}
+
+// pageLink is a link, image or ref shortcode in a page.
+type pageLink struct {
+	Target string // as written in the page
+	Kind   string // "page", "image" or "ref"
+	Ref    string // argument of a ref shortcode
+}
+
+// pageRecords caches the records of every page by file, updated as files change. Guarded by gitLock.
+var pageRecords map[string]*pageRecord
+
+func parseMarkdown(md string) ast.Node {
+	// Like Hugo, dollars aren't math unless the editor says so (see findProtected)
+	extensions := (parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock) &^ parser.MathJax
+	return parser.NewWithExtensions(extensions).Parse([]byte(md))
+}
+
+// parsePageRecord parses the content of a page file, without its bundle resources.
+func parsePageRecord(root contentRoot, rel, file, raw string) *pageRecord {
+	rel = filepath.ToSlash(rel)
+	md := removeFrontmatter(raw)
+	fm := parseFrontmatter(raw)
+	page := &pageRecord{
+		Root:      root,
+		Rel:       rel,
+		File:      file,
+		Name:      pageName(root, rel),
+		Permalink: site.permalink(root.Lang, rel, fm),
+		Terms:     map[string][]string{},
+		Anchors:   map[string]bool{},
+	}
+	page.Title, _ = fm["title"].(string)
+	if page.Title == "" {
+		page.Title = "/" + page.Name
+	}
+	_, page.InMenu = fm["menu"]
+	if _, ok := fm["menus"]; ok {
+		page.InMenu = true
+	}
+
+	aliases, _ := fm["aliases"].([]any)
+	for _, alias := range aliases {
+		if alias, ok := alias.(string); ok {
+			if !strings.HasPrefix(alias, "/") {
+				alias = path.Join(path.Dir(strings.TrimSuffix(page.Permalink, "/")), alias) // relative to the page
+			}
+			page.Aliases = append(page.Aliases, alias)
+		}
+	}
+	for _, plural := range site.Taxonomies {
+		terms, _ := fm[plural].([]any)
+		for _, term := range terms {
+			if term, ok := term.(string); ok {
+				page.Terms[plural] = append(page.Terms[plural], term)
+			}
+		}
+	}
+
+	// Headings and any other element with an id attribute can be linked to
+	doc := parseMarkdown(md)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch node := node.(type) {
+		case *ast.Heading:
+			if node.HeadingID != "" {
+				page.Anchors[node.HeadingID] = true
+			}
+		case *ast.Link:
+			if !strings.HasPrefix(string(node.Destination), "{{") { // shortcodes are found below
+				page.Links = append(page.Links, pageLink{Target: string(node.Destination), Kind: "page"})
+			}
+		case *ast.Image:
+			page.Links = append(page.Links, pageLink{Target: string(node.Destination), Kind: "image"})
+		}
+		return ast.GoToNext
+	})
+	for _, match := range htmlIDRegex.FindAllStringSubmatch(md, -1) {
+		page.Anchors[match[1]] = true
+	}
+	for _, match := range refArgRegex.FindAllStringSubmatch(md, -1) {
+		page.Links = append(page.Links, pageLink{Target: match[0], Kind: "ref", Ref: match[2]})
+	}
+	return page
+}
+
+// readPageRecord reads and parses a page file, including its bundle resources.
+func readPageRecord(root contentRoot, rel, file string) (*pageRecord, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading page: %w", err)
+	}
+	page := parsePageRecord(root, rel, file, string(raw))
+	if !isBundle(file) {
+		return page, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !isMarkdown(entry.Name()) {
+			page.Resources = append(page.Resources, entry.Name())
+		}
+	}
+	return page, nil
+}
+
+// loadPageRecords returns the record of every page, reading them all if they aren't cached. The caller must hold gitLock.
+func loadPageRecords() (map[string]*pageRecord, error) {
+	if pageRecords != nil {
+		return pageRecords, nil
+	}
+
+	records := map[string]*pageRecord{}
+	err := walkPages(func(root contentRoot, rel, file string) error {
+		page, err := readPageRecord(root, rel, file)
+		if err != nil {
+			return err
+		}
+		records[file] = page
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pageRecords = records
+	return records, nil
+}
+
+// updatePageRecords refreshes the cached records affected by changes to the given files (relative to the repo).
+// The caller must hold gitLock.
+func updatePageRecords(files []string) {
+	if pageRecords == nil {
+		return // not loaded yet
+	}
+
+	// Index files of bundles are appended while iterating, so they're visited too
+	for i := 0; i < len(files); i++ {
+		file := filepath.Clean(files[i])
+
+		// Bundle resources are part of the bundle's record
+		if !isMarkdown(file) {
+			for _, name := range []string{"index", "_index"} {
+				if index, ok := findIndexFile(filepath.Dir(file), name); ok {
+					files = append(files, index)
+				}
+			}
+			continue
+		}
+
+		root, rel, ok := rootOfFile(file)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			delete(pageRecords, file)
+			continue
+		}
+		page, err := readPageRecord(root, rel, file)
+		if err != nil {
+			slog.Error("error while updating link graph, it will be rebuilt", "file", file, "error", err)
+			pageRecords = nil
+			return
+		}
+		pageRecords[file] = page
+	}
+}
+
+// linkTarget is a page (or generated list page) that can be linked to.
+type linkTarget struct {
+	Name    string
+	Anchors map[string]bool // nil if unknown
+}
+
+// linkIndex resolves URLs and ref paths to the site's pages.
+type linkIndex struct {
+	urls      map[string]*linkTarget // by normalized URL path, with and without the base path
+	pages     map[string]*linkTarget // by page name
+	resources map[string]bool        // URL paths of bundle resources
+}
+
+func newLinkIndex(records map[string]*pageRecord) *linkIndex {
+	idx := &linkIndex{urls: map[string]*linkTarget{}, pages: map[string]*linkTarget{}, resources: map[string]bool{}}
+	add := func(permalink string, target *linkTarget) {
+		for _, p := range []string{permalink, site.basePath() + permalink} {
+			if existing := idx.urls[normalizeURLPath(p)]; existing == nil || existing.Anchors == nil {
+				idx.urls[normalizeURLPath(p)] = target
+			}
+		}
+	}
+
+	for _, page := range records {
+		target := &linkTarget{Name: page.Name, Anchors: page.Anchors}
+		idx.pages[page.Name] = target
+		add(page.Permalink, target)
+		for _, alias := range page.Aliases {
+			add(alias, target)
+		}
+
+		// Hugo generates list pages for sections and taxonomies even if they don't have an _index file
+		root, lang := page.Root.Prefix, page.Root.Lang
+		for dir := path.Dir(page.Rel); dir != "."; dir = path.Dir(dir) {
+			add(site.permalink(lang, dir+"/_index.md", nil), &linkTarget{Name: path.Join(root, dir)})
+		}
+		for _, plural := range site.Taxonomies {
+			add(site.permalink(lang, plural+"/_index.md", nil), &linkTarget{Name: path.Join(root, plural)})
+			for _, term := range page.Terms[plural] {
+				add(site.permalink(lang, plural+"/"+urlize(term)+"/_index.md", nil), &linkTarget{Name: path.Join(root, plural, term)})
+			}
+		}
+
+		for _, name := range page.Resources {
+			idx.resources[strings.ToLower(page.Permalink+name)] = true
+			idx.resources[strings.ToLower(site.basePath()+page.Permalink+name)] = true
+		}
+	}
+	return idx
+}
+
+// resolve returns the page a link refers to (if it's a page), or why it's broken.
+func (idx *linkIndex) resolve(page *pageRecord, link pageLink) (*linkTarget, string) {
+	if link.Kind == "ref" {
+		return idx.resolveRef(page, link.Ref)
+	}
+
+	u, err := url.Parse(link.Target)
+	if err != nil {
+		return nil, "invalid URL"
+	}
+	if u.Scheme != "" || u.Host != "" {
+		base, err := url.Parse(site.baseURL(""))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "") || !strings.EqualFold(u.Host, base.Host) {
+			return nil, "" // external
+		}
+	}
+	if u.Path == "" {
+		if u.Fragment != "" && !page.Anchors[u.Fragment] {
+			return nil, fmt.Sprintf("anchor #%s not found", u.Fragment)
+		}
+		return nil, ""
+	}
+
+	// Static files and bundle resources
+	if _, ok := assetFile(page.File, u.EscapedPath()); ok {
+		return nil, ""
+	}
+	if !strings.HasPrefix(u.Path, "/") && isMarkdown(u.Path) {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(page.File), filepath.FromSlash(u.Path))); err == nil {
+			return nil, ""
+		}
+		return nil, "page not found"
+	}
+
+	p := (&url.URL{Path: page.Permalink}).ResolveReference(&url.URL{Path: u.Path}).Path
+	if idx.resources[strings.ToLower(p)] || strings.HasSuffix(p, ".xml") {
+		return nil, ""
+	}
+	target := idx.urls[normalizeURLPath(p)]
+	if target == nil {
+		return nil, link.Kind + " not found"
+	}
+	if u.Fragment != "" && target.Anchors != nil && !target.Anchors[u.Fragment] {
+		return target, fmt.Sprintf("anchor #%s not found on /%s", u.Fragment, target.Name)
+	}
+	return target, ""
+}
+
+// resolveRef resolves the argument of a ref or relref shortcode like Hugo: relative to the page first, then to the content root.
+func (idx *linkIndex) resolveRef(page *pageRecord, ref string) (*linkTarget, string) {
+	ref, fragment, _ := strings.Cut(ref, "#")
+	if ref == "" {
+		ref = page.Rel
+	}
+
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "/") {
+		candidates = []string{path.Join(path.Dir(page.Rel), ref), ref}
+	}
+
+	for _, candidate := range candidates {
+		candidate = strings.Trim(candidate, "/")
+		if isMarkdown(candidate) {
+			candidate = strings.TrimSuffix(candidate, path.Ext(candidate))
+		}
+		if base := path.Base(candidate); base == "index" || base == "_index" {
+			candidate = path.Dir(candidate)
+		}
+		if candidate == "." {
+			candidate = ""
+		}
+
+		target := idx.pages[path.Join(page.Root.Prefix, candidate)]
+		if target == nil {
+			continue
+		}
+		if fragment != "" && !target.Anchors[fragment] {
+			return target, fmt.Sprintf("anchor #%s not found on /%s", fragment, target.Name)
+		}
+		return target, ""
+	}
+	return nil, "page not found"
+}
+
+// backlinks returns the pages that link to the given page.
+func backlinks(page string) ([]string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	records, err := loadPageRecords()
+	if err != nil {
+		return nil, err
+	}
+	idx := newLinkIndex(records)
+
+	page = strings.Trim(page, "/")
+	var pages []string
+	for _, source := range records {
+		if source.Name == page {
+			continue
+		}
+		for _, link := range source.Links {
+			if target, _ := idx.resolve(source, link); target != nil && target.Name == page {
+				pages = append(pages, source.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(pages)
+	return pages, nil
+}
+
+// linkedAnchors returns the anchors of a page that other pages link to, along with the pages linking to them.
+func linkedAnchors(idx *linkIndex, records map[string]*pageRecord, page string) map[string][]string {
+	anchors := map[string][]string{}
+	for _, source := range records {
+		if source.Name == page {
+			continue
+		}
+		for _, link := range source.Links {
+			target, _ := idx.resolve(source, link)
+			if target == nil || target.Name != page {
+				continue
+			}
+
+			_, fragment, _ := strings.Cut(link.Target, "#")
+			if link.Kind == "ref" {
+				_, fragment, _ = strings.Cut(link.Ref, "#")
+			}
+			if fragment != "" && !slices.Contains(anchors[fragment], source.Name) {
+				anchors[fragment] = append(anchors[fragment], source.Name)
+			}
+		}
+	}
+	for _, sources := range anchors {
+		sort.Strings(sources)
+	}
+	return anchors
+}
+
+// orphanPages returns the pages that aren't linked from any other page or menu, apart from the home page.
+func orphanPages() ([]*pageRecord, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	records, err := loadPageRecords()
+	if err != nil {
+		return nil, err
+	}
+	idx := newLinkIndex(records)
+
+	linked := map[string]bool{}
+	for _, source := range records {
+		for _, link := range source.Links {
+			if target, _ := idx.resolve(source, link); target != nil && target.Name != source.Name {
+				linked[target.Name] = true
+			}
+		}
+	}
+
+	var orphans []*pageRecord
+	for _, page := range records {
+		if !linked[page.Name] && !page.InMenu && page.Name != page.Root.Prefix {
+			orphans = append(orphans, page)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Name < orphans[j].Name })
+	return orphans, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkGraph(t *testing.T) {
+	remote := createTestRepo(t)
+	local := t.TempDir()
+	require.NoError(t, os.Chdir(local))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.WriteFile(filepath.Join("content", "_index.md"), []byte("[foo](/foo/test/)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "a.md"), []byte("[test](../test/) [self](#top)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "b.md"), []byte(`[test]({{< ref "test.md" >}})`+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "menu.md"), []byte("+++\n[menu.main]\nweight = 1\n+++\nhi\n"), 0644))
+	require.NoError(t, git("add", "."))
+	require.NoError(t, git("commit", "-m", "add pages"))
+
+	pages, err := backlinks("foo/test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "foo/a", "foo/b"}, pages)
+
+	orphans, err := orphanPages()
+	require.NoError(t, err)
+	var names []string
+	for _, page := range orphans {
+		names = append(names, page.Name)
+	}
+	assert.Equal(t, []string{"foo/a", "foo/b"}, names)
+
+	// Saving a page updates its links
+	require.NoError(t, stageUpdate("foo/a", "<p>no links</p>", "user@test.com"))
+	require.NoError(t, stageUpdate("foo/test", `<p><a href="../a/">a</a></p>`, "user@test.com"))
+
+	pages, err = backlinks("foo/test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "foo/b"}, pages)
+
+	pages, err = backlinks("foo/a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo/test"}, pages)
+
+	// So does pulling changes made elsewhere
+	require.NoError(t, pushPull())
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, git("clone", remote, "."))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "c.md"), []byte("[b](/foo/b/)\n"), 0644))
+	require.NoError(t, os.Remove(filepath.Join("content", "foo", "b.md")))
+	require.NoError(t, git("add", "-A"))
+	require.NoError(t, git("commit", "-m", "add c"))
+	require.NoError(t, git("push", "origin", "main"))
+
+	require.NoError(t, os.Chdir(local))
+	require.NoError(t, pushPull())
+
+	pages, err = backlinks("foo/test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, pages)
+
+	orphans, err = orphanPages()
+	require.NoError(t, err)
+	names = nil
+	for _, page := range orphans {
+		names = append(names, page.Name)
+	}
+	assert.Equal(t, []string{"foo/c"}, names)
+}
+
+func TestUpdateBundleResources(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	dir := filepath.Join("content", "b")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("![](img.png)\n"), 0644))
+	records, err := loadPageRecords()
+	require.NoError(t, err)
+	assert.Empty(t, records[filepath.Join(dir, "index.md")].Resources)
+
+	// Changing only a resource refreshes the bundle's record
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "img.png"), []byte("png"), 0644))
+	updatePageRecords([]string{filepath.Join(dir, "img.png")})
+	assert.Equal(t, []string{"img.png"}, pageRecords[filepath.Join(dir, "index.md")].Resources)
+}
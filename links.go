@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	htmlpkg "html"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkStyle determines how links to other pages are written: "relative" URLs or Hugo "ref" shortcodes.
+var linkStyle = "relative"
+
+var (
+	// Ref shortcodes aren't valid link destinations until Hugo expands them, so the editor sees them as /.ref/<escaped path>
+	refShortcodeRegex = regexp.MustCompile(`\]\(\{\{<\s*(ref|relref)\s+"([^"]+)"\s*>\}\}\)`)
+	refLinkRegex      = regexp.MustCompile(`\]\(/\.(ref|relref)/([^)\s]+)\)`)
+
+	// [[Page Title]] or [[Page Title|link text]], unless it's in code
+	wikiLinkRegex = regexp.MustCompile(`(?s)<pre[\s>].*?</pre>|<code[\s>].*?</code>|\[\[([^\[\]<>|]+)(?:\|([^\[\]<>]+))?\]\]`)
+)
+
+// pageSummary describes a page for linking to it.
+type pageSummary struct {
+	Name      string `json:"name"` // as used by /edit/
+	Title     string `json:"title"`
+	Link      string `json:"link"` // how the page being edited should link to this one
+	ref       string // path of the page's file for ref shortcodes
+	permalink string
+}
+
+// readPageSummaries returns every page of the site sorted by name. The caller must hold gitLock.
+func readPageSummaries() ([]*pageSummary, error) {
+	records, err := loadPageRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*pageSummary
+	for _, page := range records {
+		pages = append(pages, &pageSummary{
+			Name:      page.Name,
+			Title:     page.Title,
+			ref:       "/" + page.Rel,
+			permalink: page.Permalink,
+		})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages, nil
+}
+
+// searchPages returns the pages whose title or name contains the query, with links from the given page.
+func searchPages(query, from string) ([]*pageSummary, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	pages, err := readPageSummaries()
+	if err != nil {
+		return nil, err
+	}
+	source := findPageSummary(pages, from)
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var matches []*pageSummary
+	for _, page := range pages {
+		if strings.Contains(strings.ToLower(page.Title), query) || strings.Contains(page.Name, query) {
+			page.Link = linkTo(source, page)
+			matches = append(matches, page)
+		}
+	}
+
+	// Title matches first
+	sort.SliceStable(matches, func(i, j int) bool {
+		return strings.Contains(strings.ToLower(matches[i].Title), query) && !strings.Contains(strings.ToLower(matches[j].Title), query)
+	})
+	return matches[:min(len(matches), 20)], nil
+}
+
+func findPageSummary(pages []*pageSummary, name string) *pageSummary {
+	name = strings.Trim(name, "/")
+	for _, page := range pages {
+		if page.Name == name {
+			return page
+		}
+	}
+	return nil
+}
+
+// linkTo returns the link from one page to another in the configured style.
+// The editor's representation of ref shortcodes is returned, see encodeRefLinks.
+func linkTo(from, to *pageSummary) string {
+	if linkStyle == "ref" || from == nil {
+		return "/.ref/" + url.PathEscape(to.ref)
+	}
+	return relativeURL(from.permalink, to.permalink)
+}
+
+// relativeURL returns the URL path of target relative to the page published at source.
+func relativeURL(source, target string) string {
+	dir := source
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	from := strings.Split(strings.Trim(dir, "/"), "/")
+	to := strings.Split(strings.Trim(target, "/"), "/")
+	if from[0] == "" {
+		from = nil
+	}
+	if to[0] == "" {
+		to = nil
+	}
+
+	common := 0
+	for common < len(from) && common < len(to) && from[common] == to[common] {
+		common++
+	}
+	rel := strings.Repeat("../", len(from)-common) + strings.Join(to[common:], "/")
+	if len(to) > common && strings.HasSuffix(target, "/") {
+		rel += "/"
+	}
+	if rel == "" {
+		return "./"
+	}
+	return rel
+}
+
+// encodeRefLinks rewrites the ref and relref shortcodes used as link destinations in markdown into URLs the editor can handle.
+func encodeRefLinks(md string) string {
+	return refShortcodeRegex.ReplaceAllStringFunc(md, func(match string) string {
+		parts := refShortcodeRegex.FindStringSubmatch(match)
+		return "](/." + parts[1] + "/" + url.PathEscape(parts[2]) + ")"
+	})
+}
+
+// decodeRefLinks reverses encodeRefLinks.
+func decodeRefLinks(md string) string {
+	return refLinkRegex.ReplaceAllStringFunc(md, func(match string) string {
+		parts := refLinkRegex.FindStringSubmatch(match)
+		target, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return match
+		}
+		return fmt.Sprintf(`]({{< %s %q >}})`, parts[1], target)
+	})
+}
+
+// resolveWikiLinks replaces [[Page Title]] and [[Page Title|text]] in the html of the given page with links.
+// Titles are matched case insensitively, falling back to page names. Unknown pages are left alone.
+func resolveWikiLinks(html, page string, pages []*pageSummary) string {
+	source := findPageSummary(pages, page)
+	return wikiLinkRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := wikiLinkRegex.FindStringSubmatch(match)
+		if parts[1] == "" {
+			return match // code
+		}
+
+		title := strings.TrimSpace(htmlpkg.UnescapeString(parts[1]))
+		target := findPageByTitle(pages, title)
+		if target == nil {
+			return match
+		}
+
+		text := strings.TrimSpace(parts[2])
+		if text == "" {
+			text = strings.TrimSpace(parts[1])
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, htmlpkg.EscapeString(linkTo(source, target)), text)
+	})
+}
+
+func findPageByTitle(pages []*pageSummary, title string) *pageSummary {
+	for _, page := range pages {
+		if strings.EqualFold(page.Title, title) {
+			return page
+		}
+	}
+	return findPageSummary(pages, title)
+}
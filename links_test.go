@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelativeURL(t *testing.T) {
+	for _, tc := range []struct{ source, target, expected string }{
+		{"/foo/test/", "/foo/other/", "../other/"},
+		{"/foo/", "/foo/other/", "other/"},
+		{"/", "/foo/other/", "foo/other/"},
+		{"/foo/test/", "/", "../../"},
+		{"/foo/test/", "/foo/test/", "./"},
+		{"/foo/test.html", "/bar/other.html", "../bar/other.html"},
+	} {
+		assert.Equal(t, tc.expected, relativeURL(tc.source, tc.target), tc)
+	}
+}
+
+func TestRefLinks(t *testing.T) {
+	md := `See [other]({{< ref "/foo/other.md#usage" >}}) and [more]({{<relref "more" >}}).`
+
+	encoded := encodeRefLinks(md)
+	assert.Equal(t, `See [other](/.ref/%2Ffoo%2Fother.md%23usage) and [more](/.relref/more).`, encoded)
+	assert.Equal(t, `See [other]({{< ref "/foo/other.md#usage" >}}) and [more]({{< relref "more" >}}).`, decodeRefLinks(encoded))
+}
+
+func TestPageLinks(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "guides", "setup"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "guides", "setup", "index.md"), []byte("+++\ntitle = \"Getting Started\"\n+++\nhi\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "guides", "faq.md"), []byte("+++\ntitle = \"FAQ & Tips\"\n+++\nhi\n"), 0644))
+
+	// Search by title or name
+	pages, err := searchPages("start", "foo/test")
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "guides/setup", pages[0].Name)
+	assert.Equal(t, "Getting Started", pages[0].Title)
+	assert.Equal(t, "../../guides/setup/", pages[0].Link)
+
+	pages, err = searchPages("guides/", "foo/test")
+	require.NoError(t, err)
+	assert.Len(t, pages, 2)
+
+	// Wiki links are resolved when saving, code is left alone
+	err = stageUpdate("foo/test", `<p>Read [[getting started]] and [[FAQ &amp; Tips|the faq]], not [[Missing]].</p><p><code>[[Getting Started]]</code></p>`, "user@test.com")
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\nRead [getting started](../../guides/setup/) and [the faq](../../guides/faq/), not \\[\\[Missing]].\n\n`[[Getting Started]]`\n", string(raw))
+
+	// Ref style links survive a round trip through the editor
+	linkStyle = "ref"
+	t.Cleanup(func() { linkStyle = "relative" })
+
+	err = stageUpdate("foo/test", `<p>Read [[Getting Started]]</p>`, "user@test.com")
+	require.NoError(t, err)
+
+	raw, err = os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\nRead [Getting Started]({{< ref \"/guides/setup/index.md\" >}})\n", string(raw))
+
+	content, _, err := readPage("foo/test")
+	require.NoError(t, err)
+	assert.Contains(t, content, `<a href="/.ref/%2Fguides%2Fsetup%2Findex.md">Getting Started</a>`)
+}
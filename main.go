@@ -5,14 +5,20 @@ import (
 	"embed"
 	_ "embed"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -21,15 +27,28 @@ import (
 	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
-	"github.com/gomarkdown/markdown/parser"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
 //go:embed assets
 var assetFS embed.FS
 
 var editorTempl = template.Must(template.New("").Parse(`
+{{- if .base }}
+<base href="{{ .base }}" />
+{{- end }}
+{{- if not .source }}
 <link href="/assets/quill.snow.css" rel="stylesheet" />
 <script src="/assets/quill.js"></script>
+{{- if .katex }}
+<link href="{{ .katex | html }}/katex.min.css" rel="stylesheet" crossorigin="anonymous" />
+<script src="{{ .katex | html }}/katex.min.js" crossorigin="anonymous"{{ if .katexIntegrity }} integrity="{{ .katexIntegrity | html }}"{{ end }}></script>
+{{- end }}
+{{- if .mermaid }}
+<script src="{{ .mermaid | html }}" crossorigin="anonymous"{{ if .mermaidIntegrity }} integrity="{{ .mermaidIntegrity | html }}"{{ end }}></script>
+{{- end }}
+{{- end }}
 
 <form method="post">
 {{- if .modified -}}
@@ -37,11 +56,74 @@ var editorTempl = template.Must(template.New("").Parse(`
     Update was successful, but may take a few minutes to be applied.
     </div>
 {{- end -}}
+{{- if .error -}}
+    <div id="error-banner">{{ .error | html }}</div>
+{{- end -}}
+{{- if or .warnings .lost -}}
+    <div id="warning-banner">
+    Your changes have not been saved yet:
+    {{- if .warnings }}
+    <ul>
+    {{- range .warnings }}
+        <li>{{ . | html }}</li>
+    {{- end }}
+    </ul>
+    {{- end }}
+    {{- if .lost }}
+    <p>The following content would be removed from the page:</p>
+    {{- range .lost }}
+    <div class="lost">{{ .Kind | html }}</div>
+    <pre class="lost">{{ .Diff | html }}</pre>
+    {{- end }}
+    {{- end }}
+    <button id="force" name="force" value="1" type="submit">Save Anyway</button>
+    </div>
+{{- end -}}
 
+{{- if .source }}
+    <div id="source-editor">
+        <textarea id="source" name="content" spellcheck="false">
+{{ .content | html }}</textarea>
+        <iframe id="preview" title="Preview" sandbox="allow-same-origin allow-popups allow-popups-to-escape-sandbox"></iframe>
+    </div>
+{{- else }}
     <div id="editor">{{ .content }}</div>
+    <div id="page-picker" hidden>
+        <input type="search" placeholder="Search pages" />
+        <ul></ul>
+    </div>
+    <dialog id="diagram-editor">
+        <textarea rows="12" spellcheck="false"></textarea>
+        <div class="preview"></div>
+        <button type="button" value="done">Done</button>
+        <button type="button" value="cancel">Cancel</button>
+    </dialog>
+{{- end }}
     <button id="save" type="submit">Save Changes</button>
+{{- if .source }}
+    <a id="mode" href="{{ .edit | html }}">Visual editor</a>
+{{- else }}
+    <a id="mode" href="{{ .edit | html }}?source=1">Edit markdown</a>
+{{- end }}
+{{- if .live }}
+    <a id="live" href="{{ .live | html }}" target="_blank">View live page</a>
+{{- end }}
+{{- if .preview }}
+    <a id="site-preview" href="/build/" target="_blank">Preview site</a>
+{{- end }}
 </form>
 
+<div id="backlinks">
+    <h4>Pages linking here</h4>
+    <ul>
+    {{- range .backlinks }}
+        <li><a href="/edit/{{ . | html }}">/{{ . | html }}</a></li>
+    {{- else }}
+        <li class="none">No other pages link here yet.</li>
+    {{- end }}
+    </ul>
+</div>
+
 <style>
     body {
         font-family: sans-serif;
@@ -61,136 +143,1330 @@ var editorTempl = template.Must(template.New("").Parse(`
         cursor: pointer;
     }
 
-    #updated-banner {
-        padding: 15px;
-        background: #fffec1;
-        margin: 15px;
-    }
-</style>
+    #source-editor {
+        display: flex;
+        gap: 10px;
+        height: 60%;
+    }
+
+    #source {
+        flex: 1;
+        padding: 10px;
+        font-family: monospace;
+        font-size: 90%;
+        tab-size: 4;
+    }
+
+    #preview {
+        flex: 1;
+        border: 1px solid #ccc;
+    }
+
+    #live, #mode, #site-preview {
+        margin-left: 10px;
+        color: #000;
+    }
+
+    #updated-banner {
+        padding: 15px;
+        background: #fffec1;
+        margin: 15px;
+    }
+
+    #error-banner {
+        padding: 15px;
+        background: #ffd6d6;
+        margin: 15px;
+    }
+
+    #backlinks {
+        margin-top: 20px;
+        font-size: 90%;
+    }
+
+    #backlinks .none {
+        color: #777;
+    }
+
+    #warning-banner {
+        padding: 15px;
+        background: #ffe9c7;
+        margin: 15px;
+    }
+
+    div.lost {
+        font-size: 80%;
+        color: #777;
+    }
+
+    pre.lost {
+        margin: 2px 0 10px;
+        padding: 6px;
+        background: #ffd6d6;
+        white-space: pre-wrap;
+    }
+
+    #force {
+        border: 1px solid #000;
+        padding: 4px;
+        border-radius: 3px;
+        background: transparent;
+        cursor: pointer;
+    }
+
+    #page-picker {
+        position: absolute;
+        z-index: 10;
+        width: 300px;
+        padding: 6px;
+        background: #fff;
+        border: 1px solid #ccc;
+        box-shadow: 0 2px 8px rgba(0, 0, 0, 0.2);
+    }
+
+    #page-picker input {
+        width: 100%;
+        box-sizing: border-box;
+        padding: 4px;
+    }
+
+    #page-picker ul {
+        list-style: none;
+        padding: 0;
+        margin: 6px 0 0;
+        max-height: 250px;
+        overflow-y: auto;
+    }
+
+    #page-picker li {
+        padding: 4px;
+        cursor: pointer;
+    }
+
+    #page-picker li:hover, #page-picker li.active {
+        background: #eee;
+    }
+
+    #page-picker .name {
+        font-size: 80%;
+        color: #777;
+    }
+
+    .ql-protected, .ql-protected-inline {
+        font-family: monospace;
+        font-size: 85%;
+        color: #555;
+        background: #f3f3f3;
+        border: 1px dashed #bbb;
+        border-radius: 3px;
+        cursor: default;
+        user-select: none;
+    }
+
+    .ql-protected {
+        padding: 6px;
+        margin: 6px 0;
+        white-space: pre-wrap;
+    }
+
+    .ql-protected-inline {
+        padding: 0 3px;
+    }
+
+    .ql-diagram {
+        margin: 6px 0;
+        padding: 6px;
+        border: 1px dashed #bbb;
+        border-radius: 3px;
+        text-align: center;
+        cursor: pointer;
+    }
+
+    #diagram-editor {
+        width: 80%;
+    }
+
+    #diagram-editor textarea {
+        width: 100%;
+        box-sizing: border-box;
+        font-family: monospace;
+    }
+
+    #diagram-editor .preview {
+        margin: 10px 0;
+        min-height: 40px;
+        text-align: center;
+    }
+</style>
+
+<script>
+    // Switching editors discards unsaved changes
+    let changed = false
+    document.getElementById('mode').addEventListener('click', (event) => {
+        if (changed && !confirm('Discard your unsaved changes?')) event.preventDefault()
+    })
+{{- if .source }}
+
+    // The preview is rendered by the server like the site would, keeping its scroll position
+    const source = document.getElementById('source')
+    const preview = document.getElementById('preview')
+    let previewTimer = null
+
+    async function updatePreview() {
+        const body = new URLSearchParams({ content: source.value })
+        const resp = await fetch(location.pathname.replace(/^\/edit\//, '/render/'), { method: 'POST', body })
+        if (!resp.ok) return
+
+        const scroll = preview.contentWindow.scrollY
+        preview.onload = () => preview.contentWindow.scrollTo(0, scroll)
+        preview.srcdoc = await resp.text()
+    }
+
+    source.addEventListener('input', () => {
+        changed = true
+        clearTimeout(previewTimer)
+        previewTimer = setTimeout(updatePreview, 300)
+    })
+    updatePreview()
+</script>
+{{- else }}
+
+    // Images are uploaded to the repo instead of being embedded as data URIs
+    async function uploadImage(file, index) {
+        const body = new FormData()
+        body.append('file', file)
+
+        const resp = await fetch(location.pathname.replace(/^\/edit\//, '/upload/'), { method: 'POST', body })
+        if (!resp.ok) {
+            alert('Unable to upload ' + file.name + ': ' + await resp.text())
+            return
+        }
+
+        const { url } = await resp.json()
+        quill.insertEmbed(index, 'image', url, Quill.sources.USER)
+        quill.setSelection(index + 1, Quill.sources.SILENT)
+    }
+
+    // Attachments are inserted as a link named after the stored file
+    async function uploadAttachment(file, index) {
+        const body = new FormData()
+        body.append('file', file)
+
+        const resp = await fetch(location.pathname.replace(/^\/edit\//, '/attach/'), { method: 'POST', body })
+        if (!resp.ok) {
+            alert('Unable to attach ' + file.name + ': ' + await resp.text())
+            return
+        }
+
+        const { url, name } = await resp.json()
+        quill.insertText(index, name, 'link', url, Quill.sources.USER)
+        quill.setSelection(index + name.length, Quill.sources.SILENT)
+    }
+
+    function selectAttachment() {
+        const input = document.createElement('input')
+        input.type = 'file'
+        input.accept = '{{ .accept }}'
+        input.addEventListener('change', () => {
+            const range = quill.getSelection(true)
+            Array.from(input.files).forEach((file) => uploadAttachment(file, range.index))
+        })
+        input.click()
+    }
+
+    function selectImage() {
+        const input = document.createElement('input')
+        input.type = 'file'
+        input.accept = 'image/png, image/jpeg, image/gif'
+        input.addEventListener('change', () => {
+            const range = quill.getSelection(true)
+            Array.from(input.files).forEach((file) => uploadImage(file, range.index))
+        })
+        input.click()
+    }
+
+    // Links to other pages are picked by searching their titles
+    const picker = document.getElementById('page-picker')
+    const pickerInput = picker.querySelector('input')
+    const pickerResults = picker.querySelector('ul')
+    let pickerRange = null
+
+    function openPagePicker() {
+        pickerRange = quill.getSelection(true)
+        const bounds = quill.getBounds(pickerRange.index)
+        const container = quill.container.getBoundingClientRect()
+        picker.style.top = (window.scrollY + container.top + bounds.bottom + 5) + 'px'
+        picker.style.left = (window.scrollX + container.left + bounds.left) + 'px'
+        picker.hidden = false
+        pickerInput.value = quill.getText(pickerRange.index, pickerRange.length)
+        pickerInput.focus()
+        searchPages()
+    }
+
+    function closePagePicker() {
+        picker.hidden = true
+        quill.focus()
+    }
+
+    async function searchPages() {
+        const query = pickerInput.value
+        const from = decodeURIComponent(location.pathname.replace(/^\/edit\//, ''))
+        const resp = await fetch('/pages?' + new URLSearchParams({ q: query, from }))
+        if (!resp.ok || query !== pickerInput.value) return
+
+        pickerResults.replaceChildren(...(await resp.json()).map((page, i) => {
+            const li = document.createElement('li')
+            li.classList.toggle('active', i === 0)
+            li.append(page.title, document.createElement('br'), Object.assign(document.createElement('span'), { className: 'name', textContent: '/' + page.name }))
+            li.addEventListener('mousedown', (event) => {
+                event.preventDefault()
+                insertPageLink(page)
+            })
+            return li
+        }))
+    }
+
+    function insertPageLink(page) {
+        closePagePicker()
+        if (pickerRange.length > 0) {
+            quill.formatText(pickerRange.index, pickerRange.length, 'link', page.link, Quill.sources.USER)
+            return
+        }
+        quill.insertText(pickerRange.index, page.title, 'link', page.link, Quill.sources.USER)
+        quill.setSelection(pickerRange.index + page.title.length, Quill.sources.SILENT)
+    }
+
+    pickerInput.addEventListener('input', searchPages)
+    pickerInput.addEventListener('blur', () => picker.hidden = true)
+    pickerInput.addEventListener('keydown', (event) => {
+        const items = Array.from(pickerResults.children)
+        const active = items.findIndex((li) => li.classList.contains('active'))
+        if (event.key === 'Escape') {
+            closePagePicker()
+        } else if (event.key === 'ArrowDown' || event.key === 'ArrowUp') {
+            event.preventDefault()
+            const next = (active + (event.key === 'ArrowDown' ? 1 : -1) + items.length) % items.length
+            items.forEach((li, i) => li.classList.toggle('active', i === next))
+        } else if (event.key === 'Enter') {
+            event.preventDefault()
+            items[active]?.dispatchEvent(new MouseEvent('mousedown'))
+        }
+    })
+
+    // Existing files are picked from the media library, which posts them back to this window
+    function openMediaLibrary() {
+        const page = location.pathname.replace(/^\/edit\//, '')
+        window.open('/media/?page=' + encodeURIComponent(decodeURIComponent(page)), 'media', 'width=900,height=700')
+    }
+
+    window.addEventListener('message', (event) => {
+        if (event.origin !== location.origin || event.data.type !== 'insert-media') return
+
+        const { src, name, image } = event.data
+        const range = quill.getSelection(true)
+        if (image) {
+            quill.insertEmbed(range.index, 'image', src, Quill.sources.USER)
+            quill.setSelection(range.index + 1, Quill.sources.SILENT)
+        } else {
+            quill.insertText(range.index, name, 'link', src, Quill.sources.USER)
+            quill.setSelection(range.index + name.length, Quill.sources.SILENT)
+        }
+    })
+
+    // Shortcodes and raw html are shown as read-only placeholders, their source is restored when saving
+    const BlockEmbed = Quill.import('blots/block/embed')
+    const Embed = Quill.import('blots/embed')
+
+    function protectedLabel(source) {
+        const line = source.trim().split('\n')[0]
+        return line.length > 80 ? line.slice(0, 80) + '…' : line
+    }
+
+    class ProtectedBlock extends BlockEmbed {
+        static blotName = 'protected'
+        static tagName = 'DIV'
+        static className = 'ql-protected'
+
+        static create(source) {
+            const node = super.create()
+            node.dataset.source = source
+            node.setAttribute('contenteditable', 'false')
+            node.setAttribute('title', source)
+            node.textContent = protectedLabel(source)
+            return node
+        }
+
+        static value(node) {
+            return node.dataset.source
+        }
+    }
+
+    class ProtectedInline extends Embed {
+        static blotName = 'protected-inline'
+        static tagName = 'SPAN'
+        static className = 'ql-protected-inline'
+
+        static create(source) {
+            const node = super.create()
+            node.dataset.source = source
+            node.setAttribute('title', source)
+            node.textContent = protectedLabel(source)
+            return node
+        }
+
+        static value(node) {
+            return node.dataset.source
+        }
+    }
+
+    Quill.register(ProtectedBlock)
+    Quill.register(ProtectedInline)
+
+    // Math and mermaid blocks are edited as source in a dialog, and previewed in the editor
+    if (window.mermaid) mermaid.initialize({ startOnLoad: false })
+    let diagramCount = 0
+
+    function diagramParts(source) {
+        const lines = source.split('\n')
+        if (lines.length === 1) return { kind: 'math', open: '$$', body: source.trim().slice(2, -2), close: '$$' }
+        const open = lines[0]
+        return { kind: open.trim().startsWith('$$') ? 'math' : 'mermaid', open, body: lines.slice(1, -1).join('\n'), close: lines[lines.length - 1] }
+    }
+
+    function renderDiagram(el, source) {
+        const { kind, body } = diagramParts(source)
+        el.replaceChildren()
+        if (kind === 'math' && window.katex) {
+            katex.render(body, el, { displayMode: true, throwOnError: false })
+        } else if (kind === 'mermaid' && window.mermaid) {
+            mermaid.render('diagram-' + diagramCount++, body)
+                .then(({ svg }) => { el.innerHTML = svg })
+                .catch((err) => { el.textContent = err.message })
+        } else {
+            const pre = document.createElement('pre')
+            pre.textContent = body
+            el.appendChild(pre)
+        }
+    }
+
+    class DiagramBlock extends BlockEmbed {
+        static blotName = 'diagram'
+        static tagName = 'DIV'
+        static className = 'ql-diagram'
+
+        static create(source) {
+            const node = super.create()
+            node.dataset.source = source
+            node.setAttribute('contenteditable', 'false')
+            node.setAttribute('title', 'Click to edit')
+            renderDiagram(node, source)
+            return node
+        }
+
+        static value(node) {
+            return node.dataset.source
+        }
+    }
+    Quill.register(DiagramBlock)
+
+    // editDiagram opens the source of a diagram in the dialog, replacing it (or inserting it) at index when done
+    const diagramEditor = document.getElementById('diagram-editor')
+    function editDiagram(source, index, replace) {
+        const parts = diagramParts(source)
+        const textarea = diagramEditor.querySelector('textarea')
+        const preview = diagramEditor.querySelector('.preview')
+        const build = () => parts.open + '\n' + textarea.value + '\n' + parts.close
+
+        textarea.value = parts.body
+        textarea.oninput = () => renderDiagram(preview, build())
+        textarea.oninput()
+        diagramEditor.querySelector('[value=cancel]').onclick = () => diagramEditor.close()
+        diagramEditor.querySelector('[value=done]').onclick = () => {
+            diagramEditor.close()
+            if (replace) quill.deleteText(index, 1, Quill.sources.USER)
+            quill.insertEmbed(index, 'diagram', build(), Quill.sources.USER)
+            quill.setSelection(index + 1, Quill.sources.SILENT)
+        }
+        diagramEditor.showModal()
+        textarea.focus()
+    }
+
+    function insertDiagram(source) {
+        return () => editDiagram(source, quill.getSelection(true).index, false)
+    }
+
+    // Underlines and highlights are saved as inline html, which is only possible if the site renders it
+    const inlineHTML = {{ if .inlineHTML }}true{{ else }}false{{ end }}
+
+    class Highlight extends Quill.import('blots/inline') {
+        static blotName = 'highlight'
+        static tagName = 'MARK'
+    }
+    Quill.register(Highlight)
+
+    // Table columns can be explicitly left aligned in markdown
+    const Parchment = Quill.import('parchment')
+    Quill.register(new Parchment.ClassAttributor('align', 'ql-align', {
+        scope: Parchment.Scope.BLOCK,
+        whitelist: ['left', 'right', 'center', 'justify'],
+    }), true)
+
+    // Code blocks keep their language, like the syntax module does without needing highlight.js
+    const CodeBlock = Quill.import('formats/code-block')
+
+    class LanguageCodeBlock extends CodeBlock {
+        static create(value) {
+            const node = super.create(value)
+            if (typeof value === 'string') node.setAttribute('data-language', value)
+            return node
+        }
+
+        static formats(node) {
+            return node.getAttribute('data-language') || 'plain'
+        }
+
+        format(name, value) {
+            if (name === this.statics.blotName && value) {
+                this.domNode.setAttribute('data-language', typeof value === 'string' ? value : 'plain')
+            } else {
+                super.format(name, value)
+            }
+        }
+    }
+
+    CodeBlock.requiredContainer.allowedChildren = [LanguageCodeBlock]
+    Quill.register(LanguageCodeBlock, true)
+
+    const codeLanguages = ['plain', 'bash', 'console', 'css', 'diff', 'dockerfile', 'go', 'html', 'ini', 'java', 'javascript', 'json', 'python', 'ruby', 'rust', 'sql', 'toml', 'typescript', 'yaml']
+
+    // Callouts are blockquotes starting with their type e.g. [!NOTE]
+    function insertCallout() {
+        const type = (prompt('Callout type (note, tip, important, warning or caution)', 'note') || '').trim().toUpperCase()
+        if (!/^[A-Z]+$/.test(type)) return
+
+        const range = quill.getSelection(true)
+        const [line] = quill.getLine(range.index)
+        const index = quill.getIndex(line)
+        const marker = '[!' + type + ']\n'
+        quill.insertText(index, marker, Quill.sources.USER)
+        quill.formatLine(index, marker.length + range.length, 'blockquote', true, Quill.sources.USER)
+        quill.setSelection(range.index + marker.length, range.length, Quill.sources.SILENT)
+    }
+
+    // Footnotes are numbered after the existing ones, and defined at the end of the page
+    function insertFootnote() {
+        const range = quill.getSelection(true)
+        const used = Array.from(quill.getText().matchAll(/\[\^(\d+)\]/g), (m) => parseInt(m[1]))
+        const ref = '[^' + (Math.max(0, ...used) + 1) + ']'
+        quill.insertText(range.index + range.length, ref, Quill.sources.USER)
+
+        const end = quill.getLength() - 1
+        const definition = ref + ': '
+        quill.insertText(end, '\n' + definition, Quill.sources.USER)
+        quill.removeFormat(end + 1, definition.length, Quill.sources.USER)
+        quill.setSelection(end + 1 + definition.length, Quill.sources.SILENT)
+    }
+
+    function tableHandler(action) {
+        return () => {
+            const module = quill.getModule('table')
+            const [table] = module.getTable()
+            if (action === 'insertTable') {
+                if (!table) module.insertTable(3, 3)
+            } else if (table) {
+                module[action]()
+            }
+        }
+    }
+
+    Quill.import('ui/icons').pagelink = '<svg viewBox="0 0 18 18"><path class="ql-stroke" d="M4,2H11l3,3V16H4Z"/><line class="ql-stroke" x1="7" x2="11" y1="9" y2="9"/><line class="ql-stroke" x1="7" x2="11" y1="12" y2="12"/></svg>'
+    Quill.import('ui/icons').media = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="2" y="4" width="10" height="10" rx="1"/><path class="ql-stroke" d="M6,2H15a1,1,0,0,1,1,1v9"/></svg>'
+    Quill.import('ui/icons').attach = '<svg viewBox="0 0 18 18"><path class="ql-stroke" d="M11,5.5V12a2.5,2.5,0,0,1-5,0V4.5a1.5,1.5,0,0,1,3,0V11.5a0.5,0.5,0,0,1-1,0V6"/></svg>'
+
+    Quill.import('ui/icons').math = '<svg viewBox="0 0 18 18"><path class="ql-stroke" d="M13,4H5L9,9L5,14H13"/></svg>'
+    Quill.import('ui/icons').mermaid = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="2" y="2" width="5" height="4"/><rect class="ql-stroke" x="11" y="12" width="5" height="4"/><path class="ql-stroke" d="M4.5,6V14H11"/></svg>'
+    Quill.import('ui/icons').highlight = '<svg viewBox="0 0 18 18"><path class="ql-stroke" d="M11,3L15,7L8,14H4V10Z"/><line class="ql-stroke" x1="3" x2="15" y1="16" y2="16"/></svg>'
+    Quill.import('ui/icons').callout = '<svg viewBox="0 0 18 18"><path class="ql-stroke" d="M3,4H15V12H8L5,15V12H3Z"/><line class="ql-stroke" x1="9" x2="9" y1="6" y2="8.5"/><line class="ql-stroke" x1="9" x2="9" y1="10" y2="10.2"/></svg>'
+    Quill.import('ui/icons').footnote = '<svg viewBox="0 0 18 18"><line class="ql-stroke" x1="3" x2="11" y1="8" y2="8"/><line class="ql-stroke" x1="3" x2="9" y1="14" y2="14"/><path class="ql-stroke" d="M12.5,3.5L14,2.5V7"/></svg>'
+    Quill.import('ui/icons')['table-insert'] = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="3" y="3" width="12" height="12" rx="1"/><line class="ql-stroke" x1="3" x2="15" y1="7" y2="7"/><line class="ql-stroke" x1="3" x2="15" y1="11" y2="11"/><line class="ql-stroke" x1="9" x2="9" y1="7" y2="15"/></svg>'
+    Quill.import('ui/icons')['table-row'] = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="3" y="3" width="12" height="5" rx="1"/><line class="ql-stroke" x1="9" x2="9" y1="11" y2="16"/><line class="ql-stroke" x1="6.5" x2="11.5" y1="13.5" y2="13.5"/></svg>'
+    Quill.import('ui/icons')['table-column'] = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="3" y="3" width="5" height="12" rx="1"/><line class="ql-stroke" x1="11" x2="16" y1="9" y2="9"/><line class="ql-stroke" x1="13.5" x2="13.5" y1="6.5" y2="11.5"/></svg>'
+    Quill.import('ui/icons')['table-delete-row'] = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="3" y="3" width="12" height="5" rx="1"/><line class="ql-stroke" x1="6.5" x2="11.5" y1="13.5" y2="13.5"/></svg>'
+    Quill.import('ui/icons')['table-delete-column'] = '<svg viewBox="0 0 18 18"><rect class="ql-stroke" x="3" y="3" width="5" height="12" rx="1"/><line class="ql-stroke" x1="11" x2="16" y1="9" y2="9"/></svg>'
+
+    const quill = new Quill('#editor', {
+        theme: 'snow',
+        modules: {
+            toolbar: {
+                container: [
+                    [{ header: [1, 2, 3, 4, 5, 6, false] }],
+                    ['bold', 'italic', ...(inlineHTML ? ['underline', 'highlight'] : []), 'strike', 'code'],
+                    ['link', 'blockquote', 'callout', 'footnote'],
+                    [{ list: 'ordered' }, { list: 'bullet' }, { list: 'check' }, { indent: '-1' }, { indent: '+1' }],
+                    ['pagelink', 'image', 'attach', 'media'],
+                    ['table-insert', 'table-row', 'table-column', 'table-delete-row', 'table-delete-column'],
+                    ['code-block', 'math', 'mermaid'],
+                ],
+                handlers: {
+                    math: insertDiagram('$$\nx^2\n$$'),
+                    mermaid: insertDiagram('\u0060\u0060\u0060mermaid\ngraph TD\n    A --> B\n\u0060\u0060\u0060'),
+                    callout: insertCallout,
+                    footnote: insertFootnote,
+                    pagelink: openPagePicker,
+                    image: selectImage,
+                    attach: selectAttachment,
+                    media: openMediaLibrary,
+                    'table-insert': tableHandler('insertTable'),
+                    'table-row': tableHandler('insertRowBelow'),
+                    'table-column': tableHandler('insertColumnRight'),
+                    'table-delete-row': tableHandler('deleteRow'),
+                    'table-delete-column': tableHandler('deleteColumn'),
+                },
+            },
+            table: true,
+            keyboard: { bindings: inlineHTML ? {} : { underline: null } },
+            uploader: {
+                mimetypes: ['image/png', 'image/jpeg', 'image/gif'],
+                handler(range, files) {
+                    files.forEach((file) => uploadImage(file, range.index))
+                },
+            },
+        },
+    })
+
+    quill.root.addEventListener('click', (event) => {
+        const node = event.target.closest('.ql-diagram')
+        if (!node) return
+        const blot = Quill.find(node)
+        editDiagram(blot.value().diagram, quill.getIndex(blot), true)
+    })
+
+    if (!inlineHTML) {
+        quill.clipboard.addMatcher(Node.ELEMENT_NODE, (node, delta) => {
+            delta.ops.forEach((op) => {
+                if (op.attributes) {
+                    delete op.attributes.underline
+                    delete op.attributes.highlight
+                }
+            })
+            return delta
+        })
+    }
+
+    // The language of the code block at the cursor can be changed from the toolbar
+    const languageSelect = document.createElement('select')
+    languageSelect.id = 'code-language'
+    languageSelect.title = 'Code block language'
+    languageSelect.hidden = true
+    const languageGroup = document.createElement('span')
+    languageGroup.className = 'ql-formats'
+    languageGroup.appendChild(languageSelect)
+    quill.getModule('toolbar').container.appendChild(languageGroup)
+
+    function updateLanguageSelect() {
+        const range = quill.getSelection()
+        const language = range && quill.getFormat(range)['code-block']
+        languageSelect.hidden = !language
+        if (!language) return
+
+        const options = codeLanguages.includes(language) ? codeLanguages : codeLanguages.concat([language])
+        languageSelect.replaceChildren(...options.map((l) => new Option(l, l)), new Option('other…', ''))
+        languageSelect.value = language
+    }
+    quill.on('editor-change', updateLanguageSelect)
+
+    languageSelect.addEventListener('change', () => {
+        let language = languageSelect.value
+        if (!language) {
+            language = (prompt('Language') || '').trim()
+            if (!/^[\w#+.-]+$/.test(language)) {
+                updateLanguageSelect()
+                return
+            }
+        }
+        quill.format('code-block', language, Quill.sources.USER)
+    })
+
+    const form = document.querySelector('form')
+    const editor = document.getElementById("editor")
+    form.addEventListener('formdata', (event) => {
+        event.formData.append('content', editor.children[0].innerHTML)
+    })
+    quill.on('text-change', () => changed = true)
+</script>
+{{- end }}
+`))
+
+func main() {
+	router := http.NewServeMux()
+	assets := http.FileServer(http.FS(assetFS))
+
+	var (
+		addr           = flag.String("addr", "127.0.0.1:8080", "Address to listen on")
+		redirect       = flag.String("redirect", "https://github.com/jveski/static-wiki-editor", "URL to redirect the / route to")
+		syncInterval   = flag.Duration("sync-interval", time.Minute*5, "How often to sync git repo (not including actions caused by incoming requests)")
+		syncCooldown   = flag.Duration("sync-cooldown", time.Second*10, "Min interval between git pushes")
+		allowAnonymous = flag.Bool("allow-anonymous", false, "(insecure!) Allow anyone to edit. If false, X-Forwarded-Email is used to authenticate users")
+		remote         = flag.String("remote", "", "Git remote used when bootstrapping the local state")
+		remoteName     = flag.String("remote-name", layout.Remote, "Name of the git remote to sync with")
+		branch         = flag.String("branch", layout.Branch, "Git branch to sync with")
+		contentRoots   = flag.String("content", "content", "Comma separated content dirs, optionally mapped to a URL prefix e.g. 'content,api=docs/api'")
+		extensions     = flag.String("extensions", ".md", "Comma separated file extensions of markdown content")
+		maxRequestSize = flag.Int64("max-request-size", 32<<20, "Max size of a request in bytes, including any pasted images")
+		admins         = flag.String("admins", "", "Comma separated email addresses of users allowed to use the admin pages (everyone if --allow-anonymous is set)")
+		linkInterval   = flag.Duration("link-check-interval", time.Minute*10, "How often to check every page for broken links (0 to disable)")
+		previewCmd     = flag.String("preview-command", "", "Command that builds the site for /preview/, run in a worktree of the current commit with the output dir appended e.g. 'hugo --baseURL=/preview/ --destination' (empty to disable)")
+		previewStyles  = flag.String("preview-css", "", "Comma separated stylesheets of the site (relative to the repo) that previews load e.g. 'static/css/main.css'")
+		attachTypes    = flag.String("attachment-types", strings.Join(attachmentExtensions(), ","), "Comma separated file extensions that can be attached, optionally with the content types they must be detected as e.g. '.pdf,.dwg=application/octet-stream'")
+	)
+	flag.IntVar(&maxImageDimension, "max-image-dimension", maxImageDimension, "Images are downscaled to fit within this many pixels (0 to disable)")
+	flag.Int64Var(&maxFileSize, "max-file-size", maxFileSize, "Max size of a single uploaded file in bytes")
+	flag.StringVar(&linkStyle, "link-style", linkStyle, "How links to other pages are written: 'relative' or 'ref' (Hugo's ref shortcode)")
+	flag.StringVar(&katexURL, "katex-url", katexURL, "URL of the KaTeX dist directory used to preview math in the editor (empty to disable)")
+	flag.StringVar(&katexIntegrity, "katex-integrity", katexIntegrity, "Subresource integrity hash of katex.min.js e.g. 'sha384-...', required if --katex-url is on another site")
+	flag.StringVar(&mermaidURL, "mermaid-url", mermaidURL, "URL of the Mermaid script used to preview diagrams in the editor (empty to disable)")
+	flag.StringVar(&mermaidIntegrity, "mermaid-integrity", mermaidIntegrity, "Subresource integrity hash of the Mermaid script, required if --mermaid-url is on another site")
+	flag.DurationVar(&buildTimeout, "preview-timeout", buildTimeout, "Max duration of a build of the site preview")
+	flag.StringVar(&attachmentDir, "attachment-dir", attachmentDir, "Directory (relative to the static dir) that attachments of non-bundled pages are stored in")
+	flag.Parse()
+
+	types, err := parseAttachmentTypes(*attachTypes)
+	if err != nil {
+		panic(err)
+	}
+	attachmentTypes = types
+	buildCommand = strings.Fields(*previewCmd)
+	adminEmails := parseAdmins(*admins)
+	for _, file := range strings.Split(*previewStyles, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			previewCSS = append(previewCSS, filepath.Clean(file))
+		}
+	}
+	if katexURL != "" && katexIntegrity == "" && requiresIntegrity(katexURL) {
+		panic("--katex-integrity is required when loading KaTeX from another site")
+	}
+	if mermaidURL != "" && mermaidIntegrity == "" && requiresIntegrity(mermaidURL) {
+		panic("--mermaid-integrity is required when loading Mermaid from another site")
+	}
+	if linkStyle != "relative" && linkStyle != "ref" {
+		panic(fmt.Sprintf("unknown link style %q", linkStyle))
+	}
+
+	roots, err := parseContentRoots(*contentRoots)
+	if err != nil {
+		panic(err)
+	}
+	layout = repoLayout{
+		Remote:     *remoteName,
+		Branch:     *branch,
+		Roots:      roots,
+		Extensions: parseExtensions(*extensions),
+	}
+
+	err = initializeRepo(*remote)
+	if err != nil {
+		panic(err)
+	}
+
+	site, err = loadSiteConfig()
+	if err != nil {
+		panic(err)
+	}
+	if !isFlagSet("content") {
+		layout.Roots = site.contentRoots()
+	}
+
+	// Sync asynchronously with the remote
+	notify := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(*syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-notify:
+			}
+
+			start := time.Now()
+			slog.Info("syncing with remote...")
+
+			err := pushPull()
+			if err != nil {
+				slog.Error("error while syncing remote repository", "error", err)
+				continue
+			}
+
+			slog.Info("synced with remote", "latencyMS", time.Since(start).Milliseconds())
+			time.Sleep(*syncCooldown)
+		}
+	}()
+
+	// Check for broken links in the background
+	if *linkInterval > 0 {
+		go func() {
+			for {
+				startLinkCheck()
+				time.Sleep(*linkInterval)
+			}
+		}()
+	}
+
+	router.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+
+	// Authenticate the user (the email is set by a trusted reverse proxy)
+	authenticate := func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		email := r.Header.Get("X-Forwarded-Email")
+		if email == "" && !*allowAnonymous {
+			http.Error(w, "unauthenticated!", 401)
+			return "", false
+		}
+		if email == "" {
+			email = "<anonymous>"
+		}
+		return email, true
+	}
+
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, *redirect, http.StatusTemporaryRedirect)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			assets.ServeHTTP(w, r)
+			return
+		}
+
+		// Serve the site's static files so images render in the editor, including uploads that aren't committed yet
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		rel, ok := strings.CutPrefix(r.URL.Path, site.basePath()+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveRepoFile(w, r, filepath.Join(site.StaticDir, filepath.FromSlash(path.Clean("/"+rel))))
+	})
+
+	// Authenticate the user and require them to be an admin
+	authorizeAdmin := func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		email, ok := authenticate(w, r)
+		if !ok {
+			return "", false
+		}
+		if !*allowAnonymous && !slices.Contains(adminEmails, email) {
+			http.Error(w, "forbidden", 403)
+			return "", false
+		}
+		return email, true
+	}
+
+	scheduleSync := func() {
+		select {
+		case notify <- struct{}{}: // schedule sync unless already scheduled
+		default:
+		}
+	}
+
+	router.HandleFunc("/edit/", func(w http.ResponseWriter, r *http.Request) {
+		page := strings.TrimPrefix(r.URL.Path, "/edit/")
+		source := r.URL.Query().Get("source") != "" // edit the markdown instead of using the visual editor
+
+		email, ok := authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		// Handle form submission
+		var formError, submitted string
+		var warnings []string
+		var lost []lostContent
+		if r.Method == http.MethodPost {
+			slog.Info("staging page update", "page", page)
+
+			r.Body = http.MaxBytesReader(w, r.Body, *maxRequestSize)
+			err := r.ParseForm()
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge):
+				formError = fmt.Sprintf("Your changes were not saved: the page is larger than the %s limit.", formatSize(*maxRequestSize))
+			case err != nil:
+				http.Error(w, "invalid form", 400)
+				return
+			}
+
+			if formError == "" {
+				submitted = r.PostFormValue("content")
+			}
+
+			// Changes that might be mistakes have to be confirmed
+			if formError == "" && r.PostFormValue("force") == "" {
+				if source {
+					warnings, err = reviewSourceUpdate(page, submitted)
+				} else {
+					warnings, lost, err = reviewUpdate(page, submitted)
+				}
+				var rejected *userError
+				switch {
+				case errors.As(err, &rejected):
+					formError = "Your changes were not saved: " + rejected.Error()
+				case err != nil:
+					slog.Error("error while reviewing page update", "error", err)
+					http.Error(w, "system error", 500)
+					return
+				}
+			}
+
+			if formError == "" && len(warnings) == 0 && len(lost) == 0 {
+				if source {
+					err = stageSourceUpdate(page, submitted, email)
+				} else {
+					err = stageUpdate(page, submitted, email)
+				}
+				var rejected *userError
+				switch {
+				case errors.As(err, &rejected):
+					formError = "Your changes were not saved: " + rejected.Error()
+				case err != nil:
+					slog.Error("error while staging page update", "error", err)
+					http.Error(w, "system error", 500)
+					return
+				default:
+					scheduleSync()
+				}
+			}
+		}
+
+		// Read the current page contents
+		slog.Info("reading page", "page", page)
+		read := readPage
+		if source {
+			read = readPageSource
+		}
+		pageHTML, found, err := read(page)
+		if err != nil {
+			slog.Error("unable to read page", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
+		if !found {
+			slog.Warn("page was not found", "page", page)
+			http.Error(w, "The requested page was not found", 404)
+			return
+		}
+
+		// Relative links in bundled pages refer to the bundle's resources
+		var base string
+		if _, ok := bundleDir(page); ok {
+			base = path.Join("/resources", page) + "/"
+		}
+
+		live, _ := liveURL(page)
+
+		links, err := backlinks(page)
+		if err != nil {
+			slog.Error("unable to find backlinks", "error", err)
+		}
+
+		// Keep the user's changes around if they couldn't be saved
+		if formError != "" {
+			slog.Warn("rejected page update", "page", page, "reason", formError)
+		}
+		confirm := len(warnings) > 0 || len(lost) > 0
+		if confirm {
+			slog.Info("page update needs confirmation", "page", page, "warnings", len(warnings), "lost", len(lost))
+		}
+		if (formError != "" || confirm) && submitted != "" {
+			pageHTML = submitted
+		}
+
+		// Render the editor page
+		w.Header().Set("Content-Type", "text/html")
+		switch {
+		case formError != "":
+			w.WriteHeader(400)
+		case confirm:
+			w.WriteHeader(409)
+		}
+		err = editorTempl.Execute(w, map[string]any{
+			"content":          pageHTML,
+			"source":           source,
+			"modified":         r.Method == http.MethodPost && formError == "" && !confirm,
+			"error":            formError,
+			"warnings":         warnings,
+			"lost":             lost,
+			"backlinks":        links,
+			"base":             base,
+			"edit":             editURL(page),
+			"live":             live,
+			"accept":           strings.Join(attachmentExtensions(), ","),
+			"inlineHTML":       site.UnsafeHTML,
+			"katex":            katexURL,
+			"katexIntegrity":   katexIntegrity,
+			"mermaid":          mermaidURL,
+			"mermaidIntegrity": mermaidIntegrity,
+			"preview":          len(buildCommand) > 0,
+		})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
+
+	router.HandleFunc("POST /render/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		page := strings.TrimPrefix(r.URL.Path, "/render/")
+
+		r.Body = http.MaxBytesReader(w, r.Body, *maxRequestSize)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", 400)
+			return
+		}
+		content, err := previewHTML(r.PostFormValue("content"))
+		if err != nil {
+			slog.Error("error while rendering preview", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
+
+		var base string
+		if _, ok := bundleDir(page); ok {
+			base = path.Join("/resources", page) + "/"
+		}
+
+		// Raw html of the page mustn't be able to run scripts as the editor
+		w.Header().Set("Content-Security-Policy", "sandbox allow-popups allow-popups-to-escape-sandbox")
+		w.Header().Set("Content-Type", "text/html")
+		err = previewTempl.Execute(w, map[string]any{
+			"content":     content,
+			"base":        base,
+			"stylesheets": previewStylesheets(),
+		})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
+
+	router.HandleFunc("GET /render/css/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		file, ok := previewStylesheetFile(strings.TrimPrefix(r.URL.Path, "/render/css/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, file)
+	})
+
+	// Read the file uploaded in a multipart form, returning its content and original name
+	readUpload := func(w http.ResponseWriter, r *http.Request) ([]byte, string, bool) {
+		r.Body = http.MaxBytesReader(w, r.Body, *maxRequestSize)
+		file, header, err := r.FormFile("file")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("the upload is larger than the %s limit", formatSize(*maxRequestSize)), http.StatusRequestEntityTooLarge)
+			return nil, "", false
+		}
+		if err != nil {
+			http.Error(w, "missing file", 400)
+			return nil, "", false
+		}
+		defer file.Close()
+
+		buf, err := io.ReadAll(file)
+		if err != nil {
+			slog.Error("error while reading upload", "error", err)
+			http.Error(w, "system error", 500)
+			return nil, "", false
+		}
+		return buf, header.Filename, true
+	}
+
+	router.HandleFunc("POST /upload/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		page := strings.TrimPrefix(r.URL.Path, "/upload/")
+
+		buf, _, ok := readUpload(w, r)
+		if !ok {
+			return
+		}
+
+		slog.Info("storing uploaded image", "page", page, "bytes", len(buf))
+		src, err := storeUpload(page, buf)
+		var rejected *userError
+		if errors.As(err, &rejected) {
+			slog.Warn("rejected uploaded image", "reason", err)
+			http.Error(w, rejected.Error(), 400)
+			return
+		}
+		if err != nil {
+			slog.Error("unable to store uploaded image", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"url": src})
+	})
+
+	router.HandleFunc("POST /attach/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		page := strings.TrimPrefix(r.URL.Path, "/attach/")
+
+		buf, name, ok := readUpload(w, r)
+		if !ok {
+			return
+		}
+
+		slog.Info("storing attachment", "page", page, "name", name, "bytes", len(buf))
+		src, err := storeAttachment(page, name, buf)
+		var rejected *userError
+		if errors.As(err, &rejected) {
+			slog.Warn("rejected attachment", "reason", err)
+			http.Error(w, rejected.Error(), 400)
+			return
+		}
+		if err != nil {
+			slog.Error("unable to store attachment", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"url": src, "name": path.Base(src)})
+	})
+
+	// Resolve the page published at a given URL (e.g. from an "Edit this page" link on the live site)
+	router.HandleFunc("/edit", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+
+		page, found := findPageByURL(r.URL.Query().Get("url"))
+		if !found {
+			slog.Warn("no page found for url", "url", r.URL.Query().Get("url"))
+			http.Error(w, "The requested page was not found", 404)
+			return
+		}
+		http.Redirect(w, r, editURL(page), http.StatusFound)
+	})
+
+	router.HandleFunc("/resources/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+
+		page, name := path.Split(strings.TrimPrefix(r.URL.Path, "/resources/"))
+		dir, ok := bundleDir(page)
+		if !ok || name == "" || isMarkdown(name) {
+			http.Error(w, "The requested resource was not found", 404)
+			return
+		}
+		serveRepoFile(w, r, filepath.Join(dir, name))
+	})
+
+	router.HandleFunc("GET /pages", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
 
-<script>
-    const quill = new Quill('#editor', {
-        theme: 'snow',
-        modules: {
-            toolbar: [
-                [{ header: [1, 2, false] }],
-                ['bold', 'italic', 'underline'],
-                ['image'],
-            ],
-        },
-    })
+		pages, err := searchPages(r.URL.Query().Get("q"), r.URL.Query().Get("from"))
+		if err != nil {
+			slog.Error("unable to search pages", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
 
-    const form = document.querySelector('form')
-    const editor = document.getElementById("editor")
-    form.addEventListener('formdata', (event) => {
-        event.formData.append('content', editor.children[0].innerHTML)
-    })
-</script>
-`))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pages)
+	})
 
-func main() {
-	router := http.NewServeMux()
-	assets := http.FileServer(http.FS(assetFS))
+	router.HandleFunc("GET /media/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
 
-	var (
-		addr           = flag.String("addr", "127.0.0.1:8080", "Address to listen on")
-		redirect       = flag.String("redirect", "https://github.com/jveski/static-wiki-editor", "URL to redirect the / route to")
-		syncInterval   = flag.Duration("sync-interval", time.Minute*5, "How often to sync git repo (not including actions caused by incoming requests)")
-		syncCooldown   = flag.Duration("sync-cooldown", time.Second*10, "Min interval between git pushes")
-		allowAnonymous = flag.Bool("allow-anonymous", false, "(insecure!) Allow anyone to edit. If false, X-Forwarded-Email is used to authenticate users")
-		remote         = flag.String("remote", "", "Git remote used when bootstrapping the local state")
-	)
-	flag.Parse()
+		page := r.URL.Query().Get("page")
+		_, editing := r.URL.Query()["page"]
+		assets, err := listMedia(page)
+		if err != nil {
+			slog.Error("unable to list media", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
 
-	err := initializeRepo(*remote)
-	if err != nil {
-		panic(err)
-	}
+		w.Header().Set("Content-Type", "text/html")
+		err = mediaTempl.Execute(w, map[string]any{
+			"assets":  assets,
+			"page":    page,
+			"editing": editing,
+		})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
 
-	// Sync asynchronously with the remote
-	notify := make(chan struct{}, 1)
-	go func() {
-		ticker := time.NewTicker(*syncInterval)
-		defer ticker.Stop()
+	router.HandleFunc("/admin/assets", func(w http.ResponseWriter, r *http.Request) {
+		email, ok := authorizeAdmin(w, r)
+		if !ok {
+			return
+		}
 
-		for {
-			select {
-			case <-ticker.C:
-			case <-notify:
+		var deleted []string
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", 400)
+				return
 			}
 
-			start := time.Now()
-			slog.Info("syncing with remote...")
-
-			err := pushPull()
+			slog.Info("deleting unused assets", "files", len(r.PostForm["file"]))
+			var err error
+			deleted, err = deleteUnusedAssets(r.PostForm["file"], email)
 			if err != nil {
-				slog.Error("error while syncing remote repository", "error", err)
-				continue
+				slog.Error("error while deleting unused assets", "error", err)
+				http.Error(w, "system error", 500)
+				return
 			}
+			scheduleSync()
+		}
 
-			slog.Info("synced with remote", "latencyMS", time.Since(start).Milliseconds())
-			time.Sleep(*syncCooldown)
+		assets, err := listUnusedAssets()
+		if err != nil {
+			slog.Error("unable to list unused assets", "error", err)
+			http.Error(w, "system error", 500)
+			return
 		}
-	}()
 
-	router.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			http.Redirect(w, r, *redirect, http.StatusTemporaryRedirect)
+		w.Header().Set("Content-Type", "text/html")
+		err = orphansTempl.Execute(w, map[string]any{
+			"assets":  assets,
+			"deleted": deleted,
+		})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
+
+	router.HandleFunc("/links/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			startLinkCheck()
+			http.Redirect(w, r, "/links/", http.StatusSeeOther)
 			return
 		}
-		assets.ServeHTTP(w, r)
+
+		w.Header().Set("Content-Type", "text/html")
+		err := linksTempl.Execute(w, map[string]any{"report": latestLinkReport()})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
 	})
 
-	router.HandleFunc("/edit/", func(w http.ResponseWriter, r *http.Request) {
-		page := strings.TrimPrefix(r.URL.Path, "/edit/")
+	router.HandleFunc("/build/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		if len(buildCommand) == 0 {
+			http.Error(w, "The site preview is disabled (see --preview-command)", 404)
+			return
+		}
 
-		// Authenticate the user
-		email := r.Header.Get("X-Forwarded-Email")
-		if email == "" && !*allowAnonymous {
-			http.Error(w, "unauthenticated!", 401)
+		if r.Method == http.MethodPost {
+			startSiteBuild()
+			http.Redirect(w, r, "/build/", http.StatusSeeOther)
 			return
 		}
-		if email == "" {
-			email = "<anonymous>"
+
+		w.Header().Set("Content-Type", "text/html")
+		err := buildTempl.Execute(w, map[string]any{"build": latestSiteBuild()})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
+
+	router.HandleFunc("GET /preview/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+		dir := latestSiteBuild().Dir
+		if dir == "" {
+			http.Redirect(w, r, "/build/", http.StatusTemporaryRedirect)
+			return
+		}
+
+		// The site's scripts run, but not as the editor
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups allow-modals")
+		http.StripPrefix("/preview", http.FileServer(http.Dir(dir))).ServeHTTP(w, r)
+	})
+
+	router.HandleFunc("GET /orphans/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(w, r); !ok {
+			return
+		}
+
+		pages, err := orphanPages()
+		if err != nil {
+			slog.Error("unable to find orphan pages", "error", err)
+			http.Error(w, "system error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		err = orphanPagesTempl.Execute(w, map[string]any{"pages": pages})
+		if err != nil {
+			slog.Error("unable to render template", "error", err)
+		}
+	})
+
+	router.HandleFunc("/order/", func(w http.ResponseWriter, r *http.Request) {
+		email, ok := authenticate(w, r)
+		if !ok {
+			return
 		}
 
-		// Handle form submission
 		if r.Method == http.MethodPost {
-			slog.Info("staging page update", "page", page)
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", 400)
+				return
+			}
+
+			order := map[string][]string{}
+			for key, values := range r.PostForm {
+				if section, ok := strings.CutPrefix(key, "order:"); ok && len(values) > 0 && values[0] != "" {
+					order[section] = strings.Split(values[0], "\n")
+				}
+			}
 
-			content := r.PostFormValue("content")
-			err := stageUpdate(page, content, email)
+			slog.Info("staging page order update", "sections", len(order))
+			err := stageOrder(order, email)
 			if err != nil {
-				slog.Error("error while staging page update", "error", err)
+				slog.Error("error while staging page order update", "error", err)
 				http.Error(w, "system error", 500)
 				return
 			}
-
-			select {
-			case notify <- struct{}{}: // schedule sync unless already scheduled
-			default:
-			}
+			scheduleSync()
 		}
 
-		// Read the current page contents
-		slog.Info("reading page", "page", page)
-		pageHTML, found, err := readPage(page)
+		trees, err := readNavTree()
 		if err != nil {
-			slog.Error("unable to read page", "error", err)
+			slog.Error("unable to read content tree", "error", err)
 			http.Error(w, "system error", 500)
 			return
 		}
-		if !found {
-			slog.Warn("page was not found", "page", page)
-			http.Error(w, "The requested page was not found", 404)
-			return
-		}
 
-		// Render the editor page
 		w.Header().Set("Content-Type", "text/html")
-		err = editorTempl.Execute(w, map[string]any{
-			"content":  pageHTML,
+		err = navTempl.Execute(w, map[string]any{
+			"trees":    trees,
 			"modified": r.Method == http.MethodPost,
 		})
 		if err != nil {
@@ -201,6 +1477,32 @@ func main() {
 	panic(http.ListenAndServe(*addr, router))
 }
 
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// parseAdmins parses the comma separated email addresses of admins, ignoring surrounding whitespace.
+func parseAdmins(value string) []string {
+	var emails []string
+	for _, email := range strings.Split(value, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// userError is returned when a request is rejected because of something the user can fix, such as an invalid upload
+// or page, as opposed to failing. Its message is shown to the user.
+type userError struct{ msg string }
+
+func (e *userError) Error() string { return e.msg }
+
+func rejectf(format string, args ...any) error {
+	return &userError{msg: fmt.Sprintf(format, args...)}
+}
+
 var gitLock sync.Mutex
 
 func git(args ...string) error {
@@ -211,6 +1513,15 @@ func git(args ...string) error {
 	return nil
 }
 
+// gitOutput runs git and returns its stdout.
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return "", fmt.Errorf("%w - stderr: %s", err, exitErr.Stderr)
+	}
+	return string(out), err
+}
+
 func initializeRepo(remote string) error {
 	gitLock.Lock()
 	defer gitLock.Unlock()
@@ -221,7 +1532,7 @@ func initializeRepo(remote string) error {
 			return fmt.Errorf("initializing: %w", err)
 		}
 
-		err = git("remote", "add", "origin", remote)
+		err = git("remote", "add", layout.Remote, remote)
 		if err != nil {
 			return fmt.Errorf("adding remote: %w", err)
 		}
@@ -233,16 +1544,17 @@ func initializeRepo(remote string) error {
 		return fmt.Errorf("resetting: %w", err)
 	}
 
-	err = git("fetch", "origin", "main")
+	err = git("fetch", layout.Remote, layout.Branch)
 	if err != nil {
 		return fmt.Errorf("fetching: %w", err)
 	}
 
-	err = git("checkout", "main")
+	err = git("checkout", layout.Branch)
 	if err != nil {
 		return fmt.Errorf("checking out: %w", err)
 	}
 
+	pageRecords = nil // rebuilt from the checked out files when needed
 	return nil
 }
 
@@ -250,12 +1562,26 @@ func pushPull() error {
 	gitLock.Lock()
 	defer gitLock.Unlock()
 
-	err := git("pull", "--rebase", "origin", "main")
+	before, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("reading head: %w", err)
+	}
+
+	err = git("pull", "--rebase", layout.Remote, layout.Branch)
 	if err != nil {
 		return fmt.Errorf("fetching: %w", err)
 	}
 
-	err = git("push", "origin", "main")
+	// Keep the link graph up to date with changes made elsewhere
+	changed, err := gitOutput("diff", "-z", "--name-only", "--no-renames", strings.TrimSpace(before), "HEAD")
+	if err != nil {
+		slog.Error("unable to list pulled changes, the link graph will be rebuilt", "error", err)
+		pageRecords = nil
+	} else if changed != "" {
+		updatePageRecords(strings.Split(strings.TrimSuffix(changed, "\x00"), "\x00"))
+	}
+
+	err = git("push", layout.Remote, layout.Branch)
 	if err != nil {
 		return fmt.Errorf("pushing: %w", err)
 	}
@@ -267,52 +1593,187 @@ func readPage(page string) (string, bool, error) {
 	gitLock.Lock()
 	defer gitLock.Unlock()
 
-	raw, err := os.ReadFile(filepath.Join("content", page) + ".md")
-	if os.IsNotExist(err) {
+	path, found := resolvePage(page)
+	if !found {
 		return "", false, nil
 	}
+
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return "", false, fmt.Errorf("reading file: %w", err)
 	}
 
-	rawNoFrontmatter := removeRegex.ReplaceAllString(string(raw), "")
-	return mdToHTML(rawNoFrontmatter), true, nil
+	md := encodeRefLinks(removeFrontmatter(string(raw)))
+	return editorHTML(md), true, nil
 }
 
 func stageUpdate(page, html, email string) error {
 	gitLock.Lock()
 	defer gitLock.Unlock()
 
-	md, err := htmltomarkdown.ConvertString(html)
+	path, found := resolvePage(page)
+	if !found {
+		return fmt.Errorf("page %q does not exist", page)
+	}
+
+	// Store pasted images as files and commit any uploaded images or attachments the page references
+	html, err := storeInlineImages(html, path)
+	if err != nil {
+		return fmt.Errorf("storing images: %w", err)
+	}
+	assets := referencedFiles(html, path)
+
+	md, err := renderUpdate(page, path, html)
 	if err != nil {
 		return err
 	}
+	return writePage(page, path, md, assets, email)
+}
+
+// writePage writes the markdown of a page to its file and commits it along with the assets it references.
+// The caller must hold gitLock.
+func writePage(page, path, md string, assets []string, email string) error {
+	err := os.WriteFile(path, []byte(md), 0644)
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+	updatePageRecords(append([]string{path}, assets...))
+
+	return commitFiles(append([]string{path}, assets...), fmt.Sprintf("Update %s", page), email)
+}
+
+// reviewUpdate returns warnings about an update to a page, and the content it would remove, that the user should
+// confirm before it's saved.
+func reviewUpdate(page, html string) ([]string, []lostContent, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	path, found := resolvePage(page)
+	if !found {
+		return nil, nil, fmt.Errorf("page %q does not exist", page)
+	}
+
+	md, err := renderUpdate(page, path, html)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	path := filepath.Join("content", page) + ".md"
 	current, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("reading existing file: %w", err)
+		return nil, nil, fmt.Errorf("reading existing file: %w", err)
+	}
+	_, previousBody := splitFrontmatter(string(current))
+	_, body := splitFrontmatter(md)
+	lost, err := findLostContent(previousBody, html, body)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	md = replaceFrontmatter(md, string(current))
-	err = os.WriteFile(path, []byte(md), 0644)
+	warnings, err := linkWarnings(path, md)
 	if err != nil {
-		return fmt.Errorf("writing file: %w", err)
+		return nil, nil, err
+	}
+	return warnings, lost, nil
+}
+
+// linkWarnings returns warnings about the links that the new markdown of the page stored at path would break.
+// Links that were already broken aren't reported, since the user didn't break them.
+// The caller must hold gitLock.
+func linkWarnings(path, md string) ([]string, error) {
+	records, err := loadPageRecords()
+	if err != nil {
+		return nil, fmt.Errorf("indexing links: %w", err)
+	}
+	root, rel, _ := rootOfFile(path)
+	idx := newLinkIndex(records)
+	updated := parsePageRecord(root, rel, path, md)
+
+	broken := map[brokenLink]bool{}
+	if previous := records[path]; previous != nil {
+		for _, link := range checkPageLinks(idx, previous) {
+			broken[link] = true
+		}
+	}
+
+	var warnings []string
+	for _, link := range checkPageLinks(idx, updated) {
+		if !broken[link] {
+			warnings = append(warnings, fmt.Sprintf("Broken link to %s: %s", link.Target, link.Reason))
+		}
+	}
+
+	// Anchors can still disappear when headings are removed
+	linked := linkedAnchors(idx, records, updated.Name)
+	var anchors []string
+	for anchor := range linked {
+		if previous := records[path]; previous != nil && previous.Anchors[anchor] && !updated.Anchors[anchor] {
+			anchors = append(anchors, anchor)
+		}
+	}
+	sort.Strings(anchors)
+	for _, anchor := range anchors {
+		warnings = append(warnings, fmt.Sprintf("The anchor #%s no longer exists, but is linked from /%s", anchor, strings.Join(linked[anchor], ", /")))
+	}
+	return warnings, nil
+}
+
+// renderUpdate converts the html of a page stored at path to the markdown file that should be written.
+// The caller must hold gitLock.
+func renderUpdate(page, path, html string) (string, error) {
+	pages, err := readPageSummaries()
+	if err != nil {
+		return "", fmt.Errorf("listing pages: %w", err)
 	}
+	nonce := newNonce()
+	if strings.Contains(html, tokenPrefix+nonce) {
+		return "", rejectf("the page contains a reserved token, try saving again")
+	}
+	html, sources, err := unprotectHTML(html, nonce)
+	if err != nil {
+		return "", err
+	}
+	html = resolveWikiLinks(html, page, pages)
+	html, sources, err = semanticHTML(html, nonce, sources)
+	if err != nil {
+		return "", err
+	}
+	html, sources, err = convertBlocks(html, nonce, sources)
+	if err != nil {
+		return "", err
+	}
+
+	md, err := htmltomarkdown.ConvertString(html)
+	if err != nil {
+		return "", err
+	}
+	md = decodeRefLinks(restoreProtected(tidyMarkdown(md), nonce, sources))
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading existing file: %w", err)
+	}
+
+	// Keep the anchors of renamed headings so links to them don't break, and the formatting of unchanged blocks
+	frontmatter, body := splitFrontmatter(string(current))
+	md = preserveHeadingIDs(md, body)
 
-	err = git("add", path)
+	return frontmatter + mergeMarkdown(body, md), nil
+}
+
+// commitFiles commits the given paths, attributing the change to the (hashed) email address.
+// The caller must hold gitLock.
+func commitFiles(paths []string, message, email string) error {
+	err := git(append([]string{"add", "--"}, paths...)...)
 	if err != nil {
-		return fmt.Errorf("adding file: %w", err)
+		return fmt.Errorf("adding files: %w", err)
 	}
 
 	emailHash := md5.Sum([]byte(fmt.Sprintf("wiki-editor-%s", email)))
-	return git("commit", "--allow-empty", "-m", fmt.Sprintf("Update %s\nAuthored by: %s\n", page, hex.EncodeToString(emailHash[:])[:8]))
+	return git("commit", "--allow-empty", "-m", fmt.Sprintf("%s\nAuthored by: %s\n", message, hex.EncodeToString(emailHash[:])[:8]))
 }
 
 func mdToHTML(md string) string {
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
-	p := parser.NewWithExtensions(extensions)
-	doc := p.Parse([]byte(md))
+	doc := parseMarkdown(md)
 
 	htmlFlags := html.CommonFlags | html.HrefTargetBlank
 	opts := html.RendererOptions{Flags: htmlFlags}
@@ -321,8 +1782,21 @@ func mdToHTML(md string) string {
 	return string(markdown.Render(doc, renderer))
 }
 
-var removeRegex = regexp.MustCompile(`(?m)^\+\+\+\n([\s\S]*?)\n\+\+\+\n`)
-var replaceRegex = regexp.MustCompile(`(?m)^\+\+\+\n([\s\S]*?)\n\+\+\+\n`)
+var removeRegex = regexp.MustCompile(`(?m)^\+\+\+\r?\n([\s\S]*?)\r?\n\+\+\+\r?\n`)
+var replaceRegex = regexp.MustCompile(`(?m)^\+\+\+\r?\n([\s\S]*?)\r?\n\+\+\+\r?\n`)
+
+// YAML front matter has to start the file, since --- is also a thematic break
+var yamlFrontmatterRegex = regexp.MustCompile(`\A---\r?\n([\s\S]*?)\r?\n---\r?\n`)
+
+// removeFrontmatter returns a document without its TOML or YAML front matter.
+func removeFrontmatter(doc string) string {
+	return removeRegex.ReplaceAllString(yamlFrontmatterRegex.ReplaceAllString(doc, ""), "")
+}
+
+// isYAMLFrontmatter returns true if front matter (as returned by splitFrontmatter) is YAML rather than TOML.
+func isYAMLFrontmatter(frontmatter string) bool {
+	return strings.HasPrefix(frontmatter, "---")
+}
 
 func replaceFrontmatter(target, source string) string {
 	sourceFrontmatter := replaceRegex.FindString(source)
@@ -330,7 +1804,35 @@ func replaceFrontmatter(target, source string) string {
 		return replaceRegex.ReplaceAllString(target, "")
 	}
 	if replaceRegex.MatchString(target) {
-		return replaceRegex.ReplaceAllString(target, sourceFrontmatter)
+		return replaceRegex.ReplaceAllLiteralString(target, sourceFrontmatter)
 	}
 	return sourceFrontmatter + "\n" + target
 }
+
+// splitFrontmatter splits a document into its TOML or YAML front matter (including the delimiters) and body.
+func splitFrontmatter(doc string) (string, string) {
+	if loc := yamlFrontmatterRegex.FindStringIndex(doc); loc != nil {
+		return doc[:loc[1]], doc[loc[1]:]
+	}
+	loc := replaceRegex.FindStringIndex(doc)
+	if loc == nil || loc[0] != 0 {
+		return "", doc
+	}
+	return doc[:loc[1]], doc[loc[1]:]
+}
+
+// parseFrontmatter returns the decoded TOML or YAML front matter of a page, or an empty map if it has none.
+// Invalid front matter (which Hugo fails to build) is treated like no front matter.
+func parseFrontmatter(doc string) map[string]any {
+	fm := map[string]any{}
+	var err error
+	if match := yamlFrontmatterRegex.FindStringSubmatch(doc); match != nil {
+		err = yaml.Unmarshal([]byte(match[1]), &fm)
+	} else if match := replaceRegex.FindStringSubmatch(doc); match != nil {
+		err = toml.Unmarshal([]byte(match[1]), &fm)
+	}
+	if err != nil {
+		return map[string]any{}
+	}
+	return fm
+}
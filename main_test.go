@@ -1,7 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"image"
+	"image/png"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 
@@ -9,6 +16,11 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestParseAdmins(t *testing.T) {
+	assert.Equal(t, []string{"a@test.com", "b@test.com"}, parseAdmins(" a@test.com, b@test.com ,"))
+	assert.Empty(t, parseAdmins(""))
+}
+
 func TestGitInteractions(t *testing.T) {
 	remote := createTestRepo(t)
 	require.NoError(t, os.Chdir(t.TempDir()))
@@ -36,7 +48,7 @@ func TestGitInteractions(t *testing.T) {
 	content, found, err = readPage("foo/test")
 	require.NoError(t, err)
 	assert.True(t, found)
-	assert.Equal(t, "<h1 id=\"hello-again\">hello again</h1>\n\n<p><strong>world</strong></p>\n", content)
+	assert.Equal(t, "<h1 id=\"hello\">hello again</h1>\n\n<p><strong>world</strong></p>\n", content)
 
 	// No-op update
 	err = stageUpdate("foo/test", "<h1 id=\"hello\">hello again</h1>\n\n<p><strong>world</strong></p>\n", "user@test.com")
@@ -55,7 +67,7 @@ func TestGitInteractions(t *testing.T) {
 	require.NoError(t, git("clone", remote, "."))
 	raw, err := os.ReadFile(filepath.Join("content", "foo", "test.md"))
 	require.NoError(t, err)
-	assert.Equal(t, "+++\ntitle = foo\nmore = 123\n+++\n\n# hello again\n\n**world**", string(raw))
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\n# hello again {#hello}\n__world__\n", string(raw))
 }
 
 func createTestRepo(t *testing.T) string {
@@ -66,10 +78,148 @@ func createTestRepo(t *testing.T) string {
 	require.NoError(t, os.Chdir(t.TempDir()))
 	require.NoError(t, git("clone", dir, "."))
 	require.NoError(t, os.MkdirAll(filepath.Join("content", "foo"), 0755))
-	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "test.md"), []byte("+++\ntitle = foo\nmore = 123\n+++\n# hello\n__world__\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "test.md"), []byte("+++\ntitle = \"foo\"\nmore = 123\n+++\n# hello\n__world__\n"), 0755))
 	require.NoError(t, git("add", "."))
 	require.NoError(t, git("commit", "-m", "initial commit"))
 	require.NoError(t, git("push", "origin", "main"))
 
 	return dir
 }
+
+func TestPageBundles(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.MkdirAll(filepath.Join("content", "foo", "bundle"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "bundle", "index.md"), []byte("leaf\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "_index.md"), []byte("section\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "_index.md"), []byte("home\n"), 0644))
+
+	for page, expected := range map[string]string{
+		"":            "<p>home</p>\n",
+		"foo":         "<p>section</p>\n",
+		"foo/":        "<p>section</p>\n",
+		"foo/bundle":  "<p>leaf</p>\n",
+		"foo/bundle/": "<p>leaf</p>\n",
+	} {
+		content, found, err := readPage(page)
+		require.NoError(t, err)
+		assert.True(t, found, page)
+		assert.Equal(t, expected, content, page)
+	}
+
+	_, found, err := readPage("foo/bundle/index")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Inline images are stored next to the bundle's index.md
+	img, name := testPNG(t)
+	err = stageUpdate("foo/bundle", `<p>leaf <img src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(img)+`"></p>`, "user@test.com")
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join("content", "foo", "bundle", "index.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "leaf ![]("+name+")\n", string(raw))
+
+	raw, err = os.ReadFile(filepath.Join("content", "foo", "bundle", name))
+	require.NoError(t, err)
+	assert.Equal(t, img, raw)
+
+	dir, ok := bundleDir("foo/bundle")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join("content", "foo", "bundle"), dir)
+
+	_, ok = bundleDir("foo/test")
+	assert.False(t, ok)
+}
+
+func TestEditorModeLinks(t *testing.T) {
+	// Bundled pages set a base URL for their resources, which relative links to the editor would resolve against
+	for _, source := range []bool{false, true} {
+		var buf bytes.Buffer
+		require.NoError(t, editorTempl.Execute(&buf, map[string]any{
+			"base":   "/resources/foo/my bundle/",
+			"edit":   editURL("foo/my bundle"),
+			"source": source,
+		}))
+		if source {
+			assert.Contains(t, buf.String(), `<a id="mode" href="/edit/foo/my%20bundle">Visual editor</a>`)
+		} else {
+			assert.Contains(t, buf.String(), `<a id="mode" href="/edit/foo/my%20bundle?source=1">Edit markdown</a>`)
+		}
+	}
+}
+
+func TestImageUploads(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+	img, name := testPNG(t)
+
+	// Upload an image
+	src, err := storeUpload("foo/test", img)
+	require.NoError(t, err)
+	assert.Equal(t, "/images/"+name, src)
+
+	_, err = storeUpload("foo/test", []byte("not an image"))
+	require.Error(t, err)
+
+	_, err = storeUpload("foo/missing", img)
+	require.Error(t, err)
+
+	// Uploaded images are committed once a page references them, pasted images are stored as files too
+	err = stageUpdate("foo/test", `<p><img src="`+src+`"><img src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(img)+`"></p>`, "user@test.com")
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\n![](/images/"+name+")![](/images/"+name+")\n", string(raw))
+
+	out, err := exec.Command("git", "show", "--name-only", "--format=").CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "content/foo/test.md\nstatic/images/"+name+"\n", string(out))
+}
+
+// testPNG returns a small png image and the name it's stored under.
+func testPNG(t *testing.T) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 2, 2))))
+
+	hash := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(hash[:])[:16] + ".png"
+}
+
+func TestYAMLFrontmatter(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	md := "---\ntitle: YAML page\nweight: 5\naliases:\n  - /old-yaml/\n---\n# hello\n\n---\n\nafter the break\n"
+	file := filepath.Join("content", "foo", "yaml.md")
+	require.NoError(t, os.WriteFile(file, []byte(md), 0644))
+
+	fm := parseFrontmatter(md)
+	assert.Equal(t, "YAML page", fm["title"])
+	assert.Equal(t, 5, fm["weight"])
+
+	// Only a leading block is front matter, since --- is also a thematic break
+	frontmatter, body := splitFrontmatter("# hello\n\n---\ntitle: x\n---\n")
+	assert.Empty(t, frontmatter)
+	assert.Equal(t, "# hello\n\n---\ntitle: x\n---\n", body)
+
+	// The front matter isn't shown in the editor, and is kept when saving
+	html, found, err := readPage("foo/yaml")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "<h1 id=\"hello\">hello</h1>\n\n<hr>\n\n<p>after the break</p>\n", html)
+
+	require.NoError(t, stageUpdate("foo/yaml", "<h1 id=\"hello\">hello</h1><hr><p>after the edit</p>", "user@test.com"))
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "---\ntitle: YAML page\nweight: 5\naliases:\n  - /old-yaml/\n---\n# hello\n\n---\n\nafter the edit\n", string(raw))
+
+	page, found := findPageByURL("/old-yaml/")
+	assert.True(t, found)
+	assert.Equal(t, "foo/yaml", page)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	dataURIRegex  = regexp.MustCompile(`src="data:(image/[\w.+-]+);base64,([^"]*)"`)
+	assetRefRegex = regexp.MustCompile(`<(?:img[^>]*\ssrc|a[^>]*\shref)="([^"]+)"`)
+)
+
+// Keyed by the sniffed content type of processed images
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// imageDir is the directory (relative to the static dir) that images of non-bundled pages are stored in.
+var imageDir = "images"
+
+// storeUpload stores an image uploaded to the given page, returning the URL the page should reference it by.
+// The image isn't committed until it's referenced by a page update.
+func storeUpload(page string, buf []byte) (string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	pageFile, found := resolvePage(page)
+	if !found {
+		return "", fmt.Errorf("page %q does not exist", page)
+	}
+
+	_, src, err := storeImage(pageFile, buf)
+	return src, err
+}
+
+// storeImage writes an image for the page stored in pageFile, named by the hash of its content to deduplicate.
+// Images of bundled pages are stored as bundle resources, everything else goes in the static dir.
+// Returns the path of the stored file and the URL the page should reference it by.
+func storeImage(pageFile string, buf []byte) (string, string, error) {
+	buf, contentType, err := processImage(buf)
+	if err != nil {
+		return "", "", err
+	}
+	ext := imageExtensions[contentType]
+
+	hash := sha256.Sum256(buf)
+	name := hex.EncodeToString(hash[:])[:16] + ext
+
+	dir := filepath.Join(site.StaticDir, imageDir)
+	src := site.basePath() + "/" + path.Join(filepath.ToSlash(imageDir), name)
+	if isBundle(pageFile) {
+		dir = filepath.Dir(pageFile)
+		src = name
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("creating dir: %w", err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		return "", "", fmt.Errorf("writing image: %w", err)
+	}
+	return file, src, nil
+}
+
+// storeInlineImages stores any data URI images embedded in the html of the page stored in pageFile.
+// Returns the html with the images referencing the stored files.
+func storeInlineImages(html, pageFile string) (string, error) {
+	var err error
+	html = dataURIRegex.ReplaceAllStringFunc(html, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		var buf []byte
+		buf, err = base64.StdEncoding.DecodeString(dataURIRegex.FindStringSubmatch(match)[2])
+		if err != nil {
+			err = fmt.Errorf("decoding data uri: %w", err)
+			return match
+		}
+
+		var src string
+		_, src, err = storeImage(pageFile, buf)
+		return fmt.Sprintf("src=%q", src)
+	})
+	return html, err
+}
+
+// referencedFiles returns the files of all images and links in the html that are stored in the repo.
+func referencedFiles(html, pageFile string) []string {
+	var files []string
+	for _, match := range assetRefRegex.FindAllStringSubmatch(html, -1) {
+		if file, ok := assetFile(pageFile, match[1]); ok {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// assetFile maps a URL referenced by the page stored in pageFile to the file it refers to,
+// if it's a static file or a resource of the page's bundle.
+func assetFile(pageFile, ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	var file string
+	if strings.HasPrefix(u.Path, "/") {
+		rel, ok := strings.CutPrefix(u.Path, site.basePath()+"/")
+		if !ok {
+			return "", false
+		}
+		file = filepath.Join(site.StaticDir, filepath.FromSlash(path.Clean("/"+rel)))
+	} else {
+		if !isBundle(pageFile) {
+			return "", false
+		}
+		file = filepath.Join(filepath.Dir(pageFile), filepath.FromSlash(path.Clean("/"+u.Path)))
+	}
+
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return "", false
+	}
+	return file, true
+}
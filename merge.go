@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+)
+
+// mdBlock is a block of a markdown document: a paragraph, heading, list, fenced code block etc.
+type mdBlock struct {
+	Text string // without the final line ending
+	Sep  string // the line ending and any blank lines that follow the block
+}
+
+// splitBlocks splits a markdown document into blocks separated by blank lines. Headings are blocks of their own
+// and fenced code blocks are kept together. Returns any blank lines before the first block, and the blocks.
+func splitBlocks(md string) (string, []mdBlock) {
+	var prefix string
+	var blocks [][]string // lines of each block, including line endings
+	var seps []string
+	open := false // whether the current block continues on the next line
+	fenced := ""
+
+	for _, line := range strings.SplitAfter(md, "\n") {
+		if line == "" {
+			continue
+		}
+		content := strings.TrimRight(line, "\r\n")
+
+		if fenced != "" {
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+			if match := fenceRegex.FindStringSubmatch(content); match != nil && match[1] == fenced {
+				fenced, open = "", false
+			}
+			continue
+		}
+
+		if strings.TrimSpace(content) == "" {
+			if len(blocks) == 0 {
+				prefix += line
+			} else {
+				seps[len(seps)-1] += line
+			}
+			open = false
+			continue
+		}
+
+		fence := fenceRegex.FindStringSubmatch(content)
+		heading := atxHeadingRegex.MatchString(content)
+		if !open || fence != nil || heading {
+			blocks = append(blocks, nil)
+			seps = append(seps, "")
+		}
+		blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+		open = !heading
+		if fence != nil {
+			fenced = fence[1]
+		}
+	}
+
+	result := make([]mdBlock, len(blocks))
+	for i, lines := range blocks {
+		text := strings.Join(lines, "")
+		trimmed := strings.TrimRight(text, "\r\n")
+		result[i] = mdBlock{Text: trimmed, Sep: text[len(trimmed):] + seps[i]}
+	}
+	return prefix, result
+}
+
+// canonicalBlock returns the html a block renders to, so differently formatted but equivalent blocks can be matched.
+func canonicalBlock(text string) string {
+	return mdToHTML(strings.ReplaceAll(text, "\r\n", "\n"))
+}
+
+// mergeMarkdown returns the new version of a markdown document (as generated by htmltomarkdown), keeping the blocks
+// of the previous version that haven't changed byte for byte along with the whitespace between them.
+// Changed blocks take the line endings of the previous version.
+func mergeMarkdown(previous, md string) string {
+	prefix, before := splitBlocks(previous)
+	_, after := splitBlocks(md)
+	if len(before) == 0 {
+		return md
+	}
+
+	lineEnding := "\n"
+	if strings.Contains(previous, "\r\n") {
+		lineEnding = "\r\n"
+	}
+
+	var beforeKeys, afterKeys []string
+	for _, block := range before {
+		beforeKeys = append(beforeKeys, canonicalBlock(block.Text))
+	}
+	for _, block := range after {
+		afterKeys = append(afterKeys, canonicalBlock(block.Text))
+	}
+	paired := map[int]int{} // new block -> old block
+	for _, pair := range alignSequences(beforeKeys, afterKeys) {
+		paired[pair[1]] = pair[0]
+	}
+
+	unchanged := func(j int) bool {
+		i, ok := paired[j]
+		return ok && beforeKeys[i] == afterKeys[j]
+	}
+
+	var out strings.Builder
+	out.WriteString(prefix)
+	for j, block := range after {
+		if j > 0 {
+			// Keep the whitespace between blocks that are still next to each other, unless it no longer separates them
+			// e.g. a heading followed by a paragraph that's been replaced by two paragraphs
+			sep := strings.Repeat(lineEnding, 2)
+			i, ok := paired[j]
+			prev, prevOK := paired[j-1]
+			if ok && prevOK && i == prev+1 {
+				separates := strings.Count(before[prev].Sep, "\n") > 1 ||
+					atxHeadingRegex.MatchString(after[j-1].Text) || atxHeadingRegex.MatchString(block.Text)
+				if separates || (unchanged(j-1) && unchanged(j)) {
+					sep = before[prev].Sep
+				}
+			}
+			out.WriteString(sep)
+		}
+
+		if i, ok := paired[j]; ok && unchanged(j) {
+			out.WriteString(before[i].Text)
+		} else {
+			out.WriteString(strings.ReplaceAll(block.Text, "\n", lineEnding))
+		}
+	}
+	out.WriteString(before[len(before)-1].Sep)
+	return out.String()
+}
+
+// alignSequences returns the indexes of matching items of two sequences, using their longest common subsequence.
+// Unmatched items between two matches are paired up by position, since they've probably been modified.
+func alignSequences(before, after []string) [][2]int {
+	// lcs[i][j] is the length of the LCS of before[i:] and after[j:]
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var pairs [][2]int
+	gapI, gapJ := 0, 0
+	closeGap := func(i, j int) {
+		for k := 0; gapI+k < i && gapJ+k < j; k++ {
+			pairs = append(pairs, [2]int{gapI + k, gapJ + k})
+		}
+	}
+	for i, j := 0, 0; i < len(before) && j < len(after); {
+		switch {
+		case before[i] == after[j]:
+			closeGap(i, j)
+			pairs = append(pairs, [2]int{i, j})
+			i, j = i+1, j+1
+			gapI, gapJ = i, j
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	closeGap(len(before), len(after))
+	return pairs
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitBlocks(t *testing.T) {
+	prefix, blocks := splitBlocks("\n# Title\nintro\nmore intro\n\n\n```go\nfoo()\n\nbar()\n```\n- a\n- b\n")
+	assert.Equal(t, "\n", prefix)
+	assert.Equal(t, []mdBlock{
+		{Text: "# Title", Sep: "\n"},
+		{Text: "intro\nmore intro", Sep: "\n\n\n"},
+		{Text: "```go\nfoo()\n\nbar()\n```", Sep: "\n"},
+		{Text: "- a\n- b", Sep: "\n"},
+	}, blocks)
+}
+
+func TestMergeMarkdown(t *testing.T) {
+	const doc = "# hello\r\n__world__\r\n\r\n* one\r\n* two\r\n\r\n```\r\ncode\r\n\r\nmore code\r\n```\r\n\r\nSome *emphasis*   \r\nand a break.\r\n\r\nLast paragraph\r\n"
+
+	// Edits are applied to the html rendered from the previous version
+	edit := func(t *testing.T, from, to string) string {
+		html := mdToHTML(strings.ReplaceAll(doc, "\r\n", "\n"))
+		require.Contains(t, html, from)
+		md, err := htmltomarkdown.ConvertString(strings.Replace(html, from, to, 1))
+		require.NoError(t, err)
+		return mergeMarkdown(doc, md)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		assert.Equal(t, doc, edit(t, "", ""))
+	})
+
+	t.Run("changed paragraph", func(t *testing.T) {
+		assert.Equal(t, strings.Replace(doc, "Last paragraph", "Last **updated** paragraph", 1), edit(t, "Last paragraph", "Last <strong>updated</strong> paragraph"))
+	})
+
+	t.Run("changed heading", func(t *testing.T) {
+		assert.Equal(t, strings.Replace(doc, "# hello", "# hello again", 1), edit(t, ">hello<", ">hello again<"))
+	})
+
+	t.Run("inserted block", func(t *testing.T) {
+		assert.Equal(t, strings.Replace(doc, "Last paragraph", "New paragraph\r\n\r\nLast paragraph", 1), edit(t, "<p>Last paragraph", "<p>New paragraph</p><p>Last paragraph"))
+	})
+
+	t.Run("deleted block", func(t *testing.T) {
+		assert.Equal(t, strings.Replace(doc, "* one\r\n* two\r\n\r\n", "", 1), edit(t, "<ul>\n<li>one</li>\n<li>two</li>\n</ul>", ""))
+	})
+
+	t.Run("split paragraph", func(t *testing.T) {
+		assert.Equal(t, strings.Replace(doc, "__world__", "**wor**\r\n\r\n**ld**", 1), edit(t, "<p><strong>world</strong></p>", "<p><strong>wor</strong></p><p><strong>ld</strong></p>"))
+	})
+
+	t.Run("new document", func(t *testing.T) {
+		assert.Equal(t, "new\n", mergeMarkdown("", "new\n"))
+	})
+}
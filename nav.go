@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var navTempl = template.Must(template.New("").Parse(`
+{{- define "node" -}}
+<li {{ if .YAML }}class="yaml" title="Pages with YAML front matter can't be reordered here"{{ else if .File }}draggable="true" data-file="{{ .File | html }}"{{ end }}>
+    <span class="title">{{ .Title | html }}</span>
+    {{- range .Menus }} <span class="menu">{{ . | html }}</span>{{ end }}
+{{- if .Children }}
+    <ul data-section="{{ .Name | html }}">
+    {{- range .Children }}{{ template "node" . }}{{ end }}
+    </ul>
+{{- end }}
+</li>
+{{- end -}}
+
+<form method="post">
+{{- if .modified -}}
+    <div id="updated-banner">
+    Update was successful, but may take a few minutes to be applied.
+    </div>
+{{- end -}}
+
+    <p>Drag pages to change their order within a section.</p>
+    <ul id="tree">{{ range .trees }}{{ template "node" . }}{{ end }}</ul>
+    <button id="save" type="submit">Save Order</button>
+</form>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    #tree, #tree ul {
+        list-style: none;
+        padding-left: 20px;
+    }
+
+    #tree li > .title {
+        display: inline-block;
+        padding: 4px 8px;
+        margin: 2px 0;
+        border: 1px solid #ddd;
+        border-radius: 3px;
+    }
+
+    #tree li[draggable] > .title {
+        cursor: grab;
+    }
+
+    #tree li.yaml > .title {
+        color: #777;
+    }
+
+    #tree .menu {
+        font-size: 80%;
+        color: #777;
+    }
+
+    #save {
+        border: 1px solid #000;
+        padding: 6px;
+        border-radius: 3px;
+        background: transparent;
+        margin-top: 10px;
+        font-size: 100%;
+        cursor: pointer;
+    }
+
+    #updated-banner {
+        padding: 15px;
+        background: #fffec1;
+        margin: 15px;
+    }
+</style>
+
+<script>
+    let dragged = null
+
+    document.querySelectorAll('#tree li[draggable]').forEach((li) => {
+        li.addEventListener('dragstart', (event) => {
+            event.stopPropagation()
+            dragged = li
+        })
+        li.addEventListener('dragover', (event) => {
+            // Pages can only be reordered within their own section
+            if (!dragged || dragged === li || dragged.parentElement !== li.parentElement) return
+            event.preventDefault()
+            event.stopPropagation()
+
+            const rect = li.getBoundingClientRect()
+            const after = event.clientY > rect.top + rect.height / 2
+            li.parentElement.insertBefore(dragged, after ? li.nextSibling : li)
+        })
+    })
+
+    const form = document.querySelector('form')
+    form.addEventListener('formdata', (event) => {
+        document.querySelectorAll('#tree ul[data-section]').forEach((ul) => {
+            const files = Array.from(ul.children).map((li) => li.dataset.file).filter((f) => f)
+            event.formData.append('order:' + ul.dataset.section, files.join('\n'))
+        })
+    })
+</script>
+`))
+
+// navNode is a page or section in the content tree.
+type navNode struct {
+	Name     string // page name, as used by /edit/
+	Title    string
+	File     string // markdown file that holds the node's front matter, if any
+	YAML     bool   // the front matter is YAML, which weights can't be written to
+	Weight   int
+	Menus    []string
+	Children []*navNode
+}
+
+// readNavTree returns the tree of every content root.
+func readNavTree() ([]*navNode, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+	return readNavRoots()
+}
+
+func readNavRoots() ([]*navNode, error) {
+	var trees []*navNode
+	for _, root := range layout.Roots {
+		tree, err := readNavSection(root, "")
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, tree)
+	}
+	return trees, nil
+}
+
+func readNavSection(root contentRoot, rel string) (*navNode, error) {
+	dir := filepath.Join(root.Dir, filepath.FromSlash(rel))
+	name := path.Join(root.Prefix, rel)
+	node := &navNode{Name: name, Title: path.Base(rel)}
+	if rel == "" {
+		node.Title = "/" + root.Prefix
+	}
+	if index, ok := findIndexFile(dir, "_index"); ok {
+		if err := readNavFrontmatter(node, index); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading dir: %w", err)
+	}
+	for _, entry := range entries {
+		childRel := path.Join(rel, entry.Name())
+
+		if entry.IsDir() {
+			// Leaf bundles are pages, not sections
+			if index, ok := findIndexFile(filepath.Join(dir, entry.Name()), "index"); ok {
+				child := &navNode{Name: path.Join(root.Prefix, childRel), Title: entry.Name()}
+				if err := readNavFrontmatter(child, index); err != nil {
+					return nil, err
+				}
+				node.Children = append(node.Children, child)
+				continue
+			}
+
+			child, err := readNavSection(root, childRel)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			continue
+		}
+
+		if !isMarkdown(entry.Name()) || isBundle(entry.Name()) {
+			continue
+		}
+		title := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		child := &navNode{Name: path.Join(root.Prefix, path.Dir(childRel), title), Title: title}
+		if err := readNavFrontmatter(child, filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+	}
+
+	// Same order as Hugo: by weight (unweighted last), then title
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if a.Weight != b.Weight {
+			return b.Weight == 0 || (a.Weight != 0 && a.Weight < b.Weight)
+		}
+		return a.Title < b.Title
+	})
+
+	return node, nil
+}
+
+func readNavFrontmatter(node *navNode, path string) error {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	node.File = path
+
+	frontmatter, _ := splitFrontmatter(string(raw))
+	node.YAML = isYAMLFrontmatter(frontmatter)
+	fm := parseFrontmatter(string(raw))
+	if title, ok := fm["title"].(string); ok && title != "" {
+		node.Title = title
+	}
+	node.Weight = toInt(fm["weight"])
+
+	switch menu := fm["menu"].(type) {
+	case string:
+		node.Menus = []string{menu}
+	case []any:
+		for _, m := range menu {
+			node.Menus = append(node.Menus, fmt.Sprint(m))
+		}
+	case map[string]any:
+		for m := range menu {
+			node.Menus = append(node.Menus, m)
+		}
+		sort.Strings(node.Menus)
+	}
+
+	return nil
+}
+
+// stageOrder rewrites the weights of the pages in each section (keyed by name) to match the given order of files.
+// All changed files are committed together.
+func stageOrder(order map[string][]string, email string) error {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	trees, err := readNavRoots()
+	if err != nil {
+		return err
+	}
+
+	sections := map[string]*navNode{}
+	var walk func(*navNode)
+	walk = func(n *navNode) {
+		sections[n.Name] = n
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	for _, tree := range trees {
+		walk(tree)
+	}
+
+	// Validate everything before touching any files
+	for name, files := range order {
+		section, ok := sections[name]
+		if !ok {
+			return fmt.Errorf("section %q does not exist", name)
+		}
+		children := map[string]bool{}
+		for _, child := range section.Children {
+			if child.File != "" {
+				children[child.File] = !child.YAML
+			}
+		}
+		for _, file := range files {
+			reorderable, ok := children[file]
+			if !ok {
+				return fmt.Errorf("file %q is not part of section %q", file, name)
+			}
+			if !reorderable {
+				return fmt.Errorf("file %q has YAML front matter, which can't be reordered", file)
+			}
+		}
+	}
+
+	// Files that were already written are restored if anything fails, so they aren't committed by a later change
+	var paths, names []string
+	restore := func(err error, files ...string) error {
+		if err := git(append([]string{"checkout", "HEAD", "--"}, files...)...); err != nil {
+			slog.Warn("unable to restore reordered files", "error", err)
+		}
+		return err
+	}
+
+	for name, files := range order {
+		changed := false
+		for i, file := range files {
+			ok, err := setPageWeight(file, (i+1)*10)
+			if err != nil {
+				return restore(err, append(paths, file)...)
+			}
+			if ok {
+				paths = append(paths, file)
+				changed = true
+			}
+		}
+		if changed {
+			names = append(names, "/"+name)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+	if err := commitFiles(paths, fmt.Sprintf("Reorder %s", strings.Join(names, ", ")), email); err != nil {
+		return restore(err, paths...)
+	}
+	return nil
+}
+
+// setPageWeight sets the weight of the page and any of its menu entries that have one.
+// Returns false if nothing changed.
+func setPageWeight(path string, weight int) (bool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("reading file: %w", err)
+	}
+
+	frontmatter, body := splitFrontmatter(string(raw))
+	if isYAMLFrontmatter(frontmatter) {
+		return false, fmt.Errorf("%s has YAML front matter, which can't be reordered", path)
+	}
+	fm := parseFrontmatter(frontmatter)
+	inner := ""
+	if frontmatter != "" {
+		inner = replaceRegex.FindStringSubmatch(frontmatter)[1] + "\n"
+	}
+
+	value := strconv.Itoa(weight)
+	updated := inner
+	if toInt(fm["weight"]) != weight {
+		updated = setTOMLValue(updated, "", "weight", value)
+	}
+	if menus, ok := fm["menu"].(map[string]any); ok {
+		for name, entry := range menus {
+			if m, ok := entry.(map[string]any); ok && m["weight"] != nil && toInt(m["weight"]) != weight {
+				updated = setTOMLValue(updated, "menu."+name, "weight", value)
+			}
+		}
+	}
+	if updated == inner {
+		return false, nil
+	}
+
+	md := "+++\n" + updated + "+++\n" + body
+	if err := os.WriteFile(path, []byte(md), 0644); err != nil {
+		return false, fmt.Errorf("writing file: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStageOrder(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "a.md"), []byte("+++\ntitle = \"A\"\nweight = 10\n\n[menu.main]\nweight = 10\n+++\nbody a\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "b.md"), []byte("body b\n"), 0644))
+	require.NoError(t, git("add", "."))
+	require.NoError(t, git("commit", "-m", "add pages"))
+
+	// Weighted pages come first
+	trees, err := readNavTree()
+	require.NoError(t, err)
+	require.Len(t, trees, 1)
+	require.Len(t, trees[0].Children, 1)
+	section := trees[0].Children[0]
+	assert.Equal(t, "foo", section.Name)
+	require.Len(t, section.Children, 3)
+	assert.Equal(t, "A", section.Children[0].Title)
+	assert.Equal(t, []string{"main"}, section.Children[0].Menus)
+	assert.Equal(t, "b", section.Children[1].Title)
+	assert.Equal(t, "foo", section.Children[2].Title)
+
+	// Move b to the top
+	a := filepath.Join("content", "foo", "a.md")
+	b := filepath.Join("content", "foo", "b.md")
+	test := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, stageOrder(map[string][]string{"foo": {b, a, test}}, "user@test.com"))
+
+	raw, err := os.ReadFile(a)
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"A\"\nweight = 20\n\n[menu.main]\nweight = 20\n+++\nbody a\n", string(raw))
+
+	raw, err = os.ReadFile(b)
+	require.NoError(t, err)
+	assert.Equal(t, "+++\nweight = 10\n+++\nbody b\n", string(raw))
+
+	raw, err = os.ReadFile(test)
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\nweight = 30\n+++\n# hello\n__world__\n", string(raw))
+
+	// Everything was committed together
+	out, err := exec.Command("git", "show", "--name-only", "--format=%s").CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "Reorder /foo Authored by: 98f44770\n\ncontent/foo/a.md\ncontent/foo/b.md\ncontent/foo/test.md\n", string(out))
+
+	// Files outside of the section are rejected
+	require.Error(t, stageOrder(map[string][]string{"foo": {"main.go"}}, "user@test.com"))
+
+	// Pages with YAML front matter can't be reordered, since only TOML is rewritten
+	yaml := filepath.Join("content", "foo", "yaml.md")
+	require.NoError(t, os.WriteFile(yaml, []byte("---\ntitle: Y\n---\nbody y\n"), 0644))
+	trees, err = readNavTree()
+	require.NoError(t, err)
+	for _, child := range trees[0].Children[0].Children {
+		assert.Equal(t, child.File == yaml, child.YAML, child.File)
+	}
+	require.Error(t, stageOrder(map[string][]string{"foo": {yaml, a}}, "user@test.com"))
+	raw, err = os.ReadFile(yaml)
+	require.NoError(t, err)
+	assert.Equal(t, "---\ntitle: Y\n---\nbody y\n", string(raw))
+	require.NoError(t, os.Remove(yaml))
+
+	// Files that were written are restored if the change can't be committed
+	require.NoError(t, os.WriteFile(filepath.Join(".git", "hooks", "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0755))
+	require.Error(t, stageOrder(map[string][]string{"foo": {a, b, test}}, "user@test.com"))
+	status, err := gitOutput("status", "--porcelain")
+	require.NoError(t, err)
+	assert.Empty(t, status)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+var orphansTempl = template.Must(template.New("").Parse(`
+<form method="post">
+{{- if .deleted -}}
+    <div id="updated-banner">
+    Deleted {{ len .deleted }} file(s), the change may take a few minutes to be applied.
+    </div>
+{{- end -}}
+
+    <h1>Unused assets</h1>
+    <p>
+    These files in the static dir aren't referenced by the content, shortcodes or front matter of any page.
+    Files used by the site's layouts or theme (icons, stylesheets etc.) aren't detected, so check before deleting them.
+    </p>
+
+{{- if .assets }}
+    <label><input id="select-all" type="checkbox" /> Select all</label>
+    <table id="assets">
+    {{- range .assets }}
+        <tr>
+            <td><input type="checkbox" name="file" value="{{ .File | html }}" /></td>
+            <td class="thumb">{{ if .Image }}<img src="{{ .URL | html }}" loading="lazy" />{{ end }}</td>
+            <td><a href="{{ .URL | html }}" target="_blank">{{ .File | html }}</a></td>
+            <td class="size">{{ .Size }}</td>
+        </tr>
+    {{- end }}
+    </table>
+    <button id="save" type="submit">Delete Selected</button>
+{{- else }}
+    <p>Every asset is in use.</p>
+{{- end }}
+</form>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    #assets td {
+        padding: 4px 8px;
+    }
+
+    .thumb img {
+        max-width: 60px;
+        max-height: 40px;
+    }
+
+    .size {
+        color: #777;
+    }
+
+    #save {
+        border: 1px solid #000;
+        padding: 6px;
+        border-radius: 3px;
+        background: transparent;
+        margin-top: 10px;
+        font-size: 100%;
+        cursor: pointer;
+    }
+
+    #updated-banner {
+        padding: 15px;
+        background: #fffec1;
+        margin: 15px;
+    }
+</style>
+
+<script>
+    const selectAll = document.getElementById('select-all')
+    selectAll?.addEventListener('change', () => {
+        document.querySelectorAll('input[name=file]').forEach((input) => input.checked = selectAll.checked)
+    })
+
+    document.querySelector('form').addEventListener('submit', (event) => {
+        const count = document.querySelectorAll('input[name=file]:checked').length
+        if (!count || !confirm('Delete ' + count + ' file(s)?')) event.preventDefault()
+    })
+</script>
+`))
+
+// listUnusedAssets returns the static files that no page references.
+func listUnusedAssets() ([]*mediaAsset, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+	return readUnusedAssets()
+}
+
+func readUnusedAssets() ([]*mediaAsset, error) {
+	assets, err := readMedia("")
+	if err != nil {
+		return nil, err
+	}
+
+	// References can't all be resolved (e.g. paths built by shortcode templates), so files whose name appears
+	// anywhere in the content are never considered unused
+	var content strings.Builder
+	err = walkPages(func(root contentRoot, rel, file string) error {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading page: %w", err)
+		}
+		content.Write(raw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := content.String()
+	var unused []*mediaAsset
+	for _, asset := range assets {
+		if len(asset.Pages) == 0 && strings.HasPrefix(asset.File, filepath.Clean(site.StaticDir)+string(filepath.Separator)) &&
+			!strings.Contains(text, asset.Name) {
+			unused = append(unused, asset)
+		}
+	}
+	return unused, nil
+}
+
+// deleteUnusedAssets deletes the given files in a single commit, skipping any that are no longer unused.
+// Returns the files that were deleted.
+func deleteUnusedAssets(files []string, email string) ([]string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	unused, err := readUnusedAssets()
+	if err != nil {
+		return nil, err
+	}
+
+	// Committed files that were already deleted are restored if anything fails, so they aren't deleted by a later change
+	var deleted, tracked []string
+	restore := func(err error) error {
+		if len(tracked) > 0 {
+			if err := git(append([]string{"checkout", "HEAD", "--"}, tracked...)...); err != nil {
+				slog.Warn("unable to restore deleted files", "error", err)
+			}
+		}
+		return err
+	}
+
+	for _, asset := range unused {
+		if !slices.Contains(files, asset.File) {
+			continue
+		}
+
+		// Files that were uploaded but never committed only exist locally
+		out, err := gitOutput("ls-files", "--", asset.File)
+		if err != nil {
+			return nil, restore(fmt.Errorf("checking if %s is tracked: %w", asset.File, err))
+		}
+		if err := os.Remove(asset.File); err != nil {
+			return nil, restore(fmt.Errorf("deleting file: %w", err))
+		}
+		deleted = append(deleted, asset.File)
+		if out != "" {
+			tracked = append(tracked, asset.File)
+		}
+	}
+	if len(tracked) == 0 {
+		return deleted, nil
+	}
+
+	if err := commitFiles(tracked, "Delete "+strings.Join(tracked, ", "), email); err != nil {
+		return nil, restore(err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnusedAssets(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	dir := filepath.Join("static", "images")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for _, name := range []string{"used.png", "cover.png", "featured.png", "orphan.png", "local.png", "figure.png", "gallery.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "used.md"), []byte("![](/images/used.png)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "cover.md"), []byte("+++\nimages = [\"images/cover.png\"]\n[params]\nfeatured_image = \"/images/featured.png\"\n+++\nbody\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "figure.md"), []byte("{{< figure src=\"/images/figure.png\" >}}\n\n{{< gallery dir=\"images\" file=`gallery.png` >}}\n"), 0644))
+	require.NoError(t, git("add", "content", filepath.Join(dir, "used.png"), filepath.Join(dir, "cover.png"), filepath.Join(dir, "featured.png"), filepath.Join(dir, "orphan.png")))
+	require.NoError(t, git("commit", "-m", "add assets"))
+
+	// Shortcode arguments are references, and files named anywhere in the content are kept even if they can't be resolved
+	media, err := readMedia("")
+	require.NoError(t, err)
+	for _, asset := range media {
+		if asset.Name == "figure.png" {
+			assert.Equal(t, []string{"foo/figure"}, asset.Pages)
+		}
+	}
+
+	assets, err := listUnusedAssets()
+	require.NoError(t, err)
+	var files []string
+	for _, asset := range assets {
+		files = append(files, asset.File)
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "local.png"), filepath.Join(dir, "orphan.png")}, files)
+
+	// Referenced files are never deleted, and uncommitted files are deleted without a commit
+	// Committed files are restored if their deletion can't be committed
+	hook := filepath.Join(".git", "hooks", "pre-commit")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0755))
+	_, err = deleteUnusedAssets([]string{filepath.Join(dir, "orphan.png")}, "admin@test.com")
+	require.Error(t, err)
+	assert.FileExists(t, filepath.Join(dir, "orphan.png"))
+	status, err := gitOutput("status", "--porcelain", "--", filepath.Join(dir, "orphan.png"))
+	require.NoError(t, err)
+	assert.Empty(t, status)
+	require.NoError(t, os.Remove(hook))
+
+	deleted, err := deleteUnusedAssets([]string{filepath.Join(dir, "orphan.png"), filepath.Join(dir, "local.png"), filepath.Join(dir, "used.png"), filepath.Join(dir, "figure.png")}, "admin@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "local.png"), filepath.Join(dir, "orphan.png")}, deleted)
+	assert.FileExists(t, filepath.Join(dir, "used.png"))
+	assert.FileExists(t, filepath.Join(dir, "figure.png"))
+	assert.NoFileExists(t, filepath.Join(dir, "orphan.png"))
+	assert.NoFileExists(t, filepath.Join(dir, "local.png"))
+
+	out, err := exec.Command("git", "show", "--name-status", "--format=%s").CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "Delete static/images/orphan.png Authored by: 88974e7e\n\nD\tstatic/images/orphan.png\n", string(out))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmlpkg "html"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+var previewTempl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8" />
+<base {{ if .base }}href="{{ .base }}" {{ end }}target="_blank" />
+{{- range .stylesheets }}
+<link href="{{ . | html }}" rel="stylesheet" />
+{{- end }}
+<style>
+{{- if not .stylesheets }}
+    body {
+        font-family: sans-serif;
+    }
+{{- end }}
+
+    .shortcode {
+        font-family: monospace;
+        font-size: 85%;
+        color: #555;
+        background: #f3f3f3;
+        border: 1px dashed #bbb;
+        border-radius: 3px;
+    }
+
+    div.shortcode {
+        padding: 6px;
+        margin: 6px 0;
+        white-space: pre-wrap;
+    }
+</style>
+</head>
+<body>
+<main>
+{{ .content }}
+</main>
+</body>
+</html>
+`))
+
+// Stylesheets (relative to the root of the repo) that previews load to look like the site
+var previewCSS []string
+
+// newMarkdownRenderer returns a Goldmark renderer configured like Hugo's.
+func newMarkdownRenderer(c siteConfig) goldmark.Markdown {
+	available := map[string]goldmark.Extender{
+		"typographer":    extension.Typographer,
+		"footnote":       extension.Footnote,
+		"definitionlist": extension.DefinitionList,
+		"table":          extension.Table,
+		"strikethrough":  extension.Strikethrough,
+		"linkify":        extension.Linkify,
+		"tasklist":       extension.TaskList,
+	}
+	var extensions []goldmark.Extender
+	for _, name := range markupExtensions {
+		if c.Markup.Extensions[name] {
+			extensions = append(extensions, available[name])
+		}
+	}
+
+	var parserOptions []parser.Option
+	if c.Markup.AutoHeadingID {
+		parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+	}
+	if c.Markup.Attributes {
+		parserOptions = append(parserOptions, parser.WithAttribute())
+	}
+
+	var rendererOptions []renderer.Option
+	if c.UnsafeHTML {
+		rendererOptions = append(rendererOptions, html.WithUnsafe())
+	}
+	if c.Markup.HardWraps {
+		rendererOptions = append(rendererOptions, html.WithHardWraps())
+	}
+	if c.Markup.XHTML {
+		rendererOptions = append(rendererOptions, html.WithXHTML())
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
+	)
+}
+
+// previewHTML renders a markdown document (including its front matter) like the site would. Shortcodes can't be
+// rendered without the site's templates, so they're shown as placeholders.
+func previewHTML(md string) (string, error) {
+	_, body := splitFrontmatter(md)
+	body = encodeRefLinks(body)
+
+	nonce := newNonce()
+	var shortcodes []string
+	var protected strings.Builder
+	last := 0
+	for _, r := range findProtected(body) {
+		if !strings.HasPrefix(body[r.Start:r.End], "{{") {
+			continue // html is rendered according to the config
+		}
+		protected.WriteString(body[last:r.Start])
+		protected.WriteString(protectedToken(nonce, len(shortcodes)))
+		shortcodes = append(shortcodes, body[r.Start:r.End])
+		last = r.End
+	}
+	protected.WriteString(body[last:])
+
+	var out bytes.Buffer
+	if err := newMarkdownRenderer(site).Convert([]byte(protected.String()), &out); err != nil {
+		return "", fmt.Errorf("rendering markdown: %w", err)
+	}
+
+	protectedRegex, blockTokenRegex := tokenRegexes(nonce)
+	placeholder := func(match string, regex *regexp.Regexp, format string) string {
+		i, err := strconv.Atoi(regex.FindStringSubmatch(match)[1])
+		if err != nil || i >= len(shortcodes) {
+			return match
+		}
+		return fmt.Sprintf(format, htmlpkg.EscapeString(shortcodes[i]))
+	}
+	rendered := blockTokenRegex.ReplaceAllStringFunc(out.String(), func(match string) string {
+		return placeholder(match, blockTokenRegex, `<div class="shortcode">%s</div>`)
+	})
+	return protectedRegex.ReplaceAllStringFunc(rendered, func(match string) string {
+		return placeholder(match, protectedRegex, `<code class="shortcode">%s</code>`)
+	}), nil
+}
+
+// previewStylesheets returns the URLs of the stylesheets previews load. Stylesheets in the static dir are loaded from
+// where they're published, so the URLs they reference work too.
+func previewStylesheets() []string {
+	var urls []string
+	for _, file := range previewCSS {
+		if rel, err := filepath.Rel(site.StaticDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			urls = append(urls, site.basePath()+"/"+filepath.ToSlash(rel))
+		} else {
+			urls = append(urls, "/render/css/"+filepath.ToSlash(file))
+		}
+	}
+	return urls
+}
+
+// previewStylesheetFile returns the file of a stylesheet requested from /render/css/, as long as it's configured.
+func previewStylesheetFile(name string) (string, bool) {
+	file := filepath.Clean(filepath.FromSlash(name))
+	return file, slices.Contains(previewCSS, file)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewHTML(t *testing.T) {
+	defer func(c siteConfig) { site = c }(site)
+	site = defaultSiteConfig()
+
+	for _, tc := range []struct{ name, md, expected string }{
+		{
+			name:     "front matter",
+			md:       "+++\ntitle = \"x\"\n+++\n# Hello world\n",
+			expected: "<h1 id=\"hello-world\">Hello world</h1>\n",
+		},
+		{
+			name:     "heading attributes",
+			md:       "## Install {#setup .big}\n",
+			expected: "<h2 id=\"setup\" class=\"big\">Install</h2>\n",
+		},
+		{
+			name:     "typographer",
+			md:       "\"quoted\" -- text...\n",
+			expected: "<p>&ldquo;quoted&rdquo; &ndash; text&hellip;</p>\n",
+		},
+		{
+			name:     "extensions",
+			md:       "- [x] ~~done~~\n\n| a |\n| - |\n| 1 |\n",
+			expected: "<ul>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\"> <del>done</del></li>\n</ul>\n<table>\n<thead>\n<tr>\n<th>a</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name:     "raw html",
+			md:       "<u>under</u>\n",
+			expected: "<p><!-- raw HTML omitted -->under<!-- raw HTML omitted --></p>\n",
+		},
+		{
+			name:     "shortcodes",
+			md:       "{{< figure src=\"a.png\" >}}\n\nsee {{% param \"x\" %}}\n",
+			expected: "<div class=\"shortcode\">{{&lt; figure src=&#34;a.png&#34; &gt;}}</div>\n<p>see <code class=\"shortcode\">{{% param &#34;x&#34; %}}</code></p>\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			html, err := previewHTML(tc.md)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, html)
+		})
+	}
+
+	// The site's config is followed
+	site.UnsafeHTML = true
+	site.Markup.Extensions["typographer"] = false
+	site.Markup.HardWraps = true
+	html, err := previewHTML("\"a\" <u>b</u>\nc\n")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>&quot;a&quot; <u>b</u><br>\nc</p>\n", html)
+}
+
+func TestPreviewStylesheets(t *testing.T) {
+	defer func(c siteConfig, css []string) { site, previewCSS = c, css }(site, previewCSS)
+	site = parseSiteConfig(map[string]any{"baseurl": "https://example.com/docs/"})
+	previewCSS = []string{"static/css/main.css", "assets/site.css"}
+
+	assert.Equal(t, []string{"/docs/css/main.css", "/render/css/assets/site.css"}, previewStylesheets())
+
+	file, ok := previewStylesheetFile("assets/site.css")
+	assert.True(t, ok)
+	assert.Equal(t, "assets/site.css", file)
+
+	_, ok = previewStylesheetFile("assets/../hugo.toml")
+	assert.False(t, ok)
+}
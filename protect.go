@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	htmlpkg "html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	htmlnode "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Hugo shortcodes and raw html can't be represented in the editor, so they're replaced by placeholders that hold
+// their source. The placeholders are rendered as non-editable blots, and swapped back for their source when saving.
+// Math and mermaid blocks are replaced the same way, but their blots can be edited (see diagramKind).
+
+var (
+	shortcodeRegex   = regexp.MustCompile(`(?s)\{\{[<%].*?[>%]\}\}`)
+	htmlBlockRegex   = regexp.MustCompile(`^ {0,3}(<!--|<\?|<![a-zA-Z]|</?(?i:address|article|aside|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|picture|search|section|source|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul|video|audio|script|pre|style|textarea)(\s|/?>|$)|</?[a-zA-Z][\w-]*(\s[^<>]*)?/?>\s*$)`)
+	inlineHTMLRegex  = regexp.MustCompile("``[^`]*``|`[^`\n]*`|</?[a-zA-Z][\\w-]*(\\s[^<>]*)?/?>|<!--.*?-->")
+	shortcodeNameRex = regexp.MustCompile(`^(/?)\s*([\w./-]+)`)
+	formatTagRegex   = regexp.MustCompile(`^</?(?:u|mark)>$`)
+	inlineMathRegex  = regexp.MustCompile("``[^`]*``|`[^`\n]*`|\\\\\\$|\\$\\$?[^\\s$](?:[^$\n]*[^\\s$])?\\$?\\$")
+)
+
+// protectedRange is a span of a markdown document that must be preserved verbatim.
+type protectedRange struct {
+	Start, End int
+}
+
+// findProtected returns the shortcodes (including the content of paired shortcodes), html blocks, inline html
+// tags, math and mermaid code blocks of a markdown document, outside of code.
+func findProtected(md string) []protectedRange {
+	var candidates []protectedRange
+	var code []protectedRange
+
+	// Fenced code blocks, html blocks and math blocks, by line
+	fenced := ""
+	blockStart := true
+	htmlStart, mathStart := -1, -1
+	offset := 0
+	for _, line := range strings.SplitAfter(md, "\n") {
+		content := strings.TrimRight(line, "\r\n")
+		blank := strings.TrimSpace(content) == ""
+		switch {
+		case fenced != "":
+			code[len(code)-1].End = offset + len(line)
+			if match := fenceRegex.FindStringSubmatch(content); match != nil && match[1] == fenced {
+				fenced = ""
+				if start := code[len(code)-1].Start; diagramKind(md[start:offset]) == "mermaid" {
+					candidates = append(candidates, protectedRange{start, offset + len(content)})
+				}
+			}
+		case htmlStart >= 0 && blank:
+			candidates = append(candidates, protectedRange{htmlStart, len(strings.TrimRight(md[:offset], "\r\n"))})
+			htmlStart = -1
+		case htmlStart >= 0:
+		case mathStart >= 0:
+			if strings.HasSuffix(strings.TrimSpace(content), "$$") {
+				candidates = append(candidates, protectedRange{mathStart, offset + len(content)})
+				mathStart = -1
+			}
+		case fenceRegex.MatchString(content):
+			fenced = fenceRegex.FindStringSubmatch(content)[1]
+			code = append(code, protectedRange{offset, offset + len(line)})
+		case blockStart && htmlBlockRegex.MatchString(content):
+			htmlStart = offset
+		case blockStart && strings.HasPrefix(strings.TrimSpace(content), "$$"):
+			if trimmed := strings.TrimSpace(content); len(trimmed) > 4 && strings.HasSuffix(trimmed, "$$") {
+				candidates = append(candidates, protectedRange{offset, offset + len(content)})
+			} else {
+				mathStart = offset
+			}
+		}
+		blockStart = blank
+		offset += len(line)
+	}
+	if htmlStart >= 0 {
+		candidates = append(candidates, protectedRange{htmlStart, len(strings.TrimRight(md, "\r\n"))})
+	}
+	inCode := func(i int) bool {
+		for _, r := range code {
+			if i >= r.Start && i < r.End {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Shortcodes, paired with their closing tag if they have one
+	shortcodes := shortcodeRegex.FindAllStringIndex(md, -1)
+	for k, loc := range shortcodes {
+		if inCode(loc[0]) {
+			continue
+		}
+		inner := strings.TrimSpace(md[loc[0]+3 : loc[1]-3])
+		match := shortcodeNameRex.FindStringSubmatch(inner)
+		if match == nil || match[1] != "" || strings.HasSuffix(inner, "/") {
+			candidates = append(candidates, protectedRange{loc[0], loc[1]})
+			continue
+		}
+
+		end := loc[1]
+		depth := 0
+		for _, next := range shortcodes[k+1:] {
+			other := shortcodeNameRex.FindStringSubmatch(strings.TrimSpace(md[next[0]+3 : next[1]-3]))
+			if other == nil || other[2] != match[2] {
+				continue
+			}
+			if other[1] == "" {
+				depth++
+			} else if depth > 0 {
+				depth--
+			} else {
+				end = next[1]
+				break
+			}
+		}
+		candidates = append(candidates, protectedRange{loc[0], end})
+	}
+
+	// Inline html, skipping code spans and the tags the editor can format with (see semanticHTML)
+	for _, loc := range inlineHTMLRegex.FindAllStringIndex(md, -1) {
+		if site.UnsafeHTML && formatTagRegex.MatchString(md[loc[0]:loc[1]]) {
+			continue
+		}
+		if md[loc[0]] != '`' && !inCode(loc[0]) {
+			candidates = append(candidates, protectedRange{loc[0], loc[1]})
+		}
+	}
+
+	// Inline math, which gomarkdown renders as \(...\). Like pandoc, there's no space just inside the dollars
+	for _, loc := range inlineMathRegex.FindAllStringIndex(md, -1) {
+		if md[loc[0]] == '$' && !inCode(loc[0]) {
+			candidates = append(candidates, protectedRange{loc[0], loc[1]})
+		}
+	}
+
+	// Outermost ranges win
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Start != candidates[j].Start {
+			return candidates[i].Start < candidates[j].Start
+		}
+		return candidates[i].End > candidates[j].End
+	})
+	var ranges []protectedRange
+	for _, r := range candidates {
+		if len(ranges) == 0 || r.Start >= ranges[len(ranges)-1].End {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// protectMarkdown replaces the protected constructs of a markdown document with tokens, returning their source.
+// Constructs that fill whole blocks become paragraphs of their own.
+func protectMarkdown(md, nonce string) (string, []string) {
+	var sources []string
+	var out strings.Builder
+	last := 0
+	for _, r := range findProtected(md) {
+		out.WriteString(md[last:r.Start])
+		out.WriteString(protectedToken(nonce, len(sources)))
+		sources = append(sources, md[r.Start:r.End])
+		last = r.End
+	}
+	out.WriteString(md[last:])
+	return out.String(), sources
+}
+
+// Tokens include a random nonce (see newNonce), so text that looks like a token is never replaced
+const tokenPrefix = "XPROTECTED"
+
+func protectedToken(nonce string, i int) string {
+	return tokenPrefix + nonce + strconv.Itoa(i) + "X"
+}
+
+// tokenRegexes returns regexes matching the tokens with the given nonce, and paragraphs of a single token.
+func tokenRegexes(nonce string) (*regexp.Regexp, *regexp.Regexp) {
+	token := tokenPrefix + regexp.QuoteMeta(nonce) + `(\d+)X`
+	return regexp.MustCompile(token), regexp.MustCompile(`<p>` + token + `</p>`)
+}
+
+// newNonce returns a random string for the tokens of a single render or save.
+func newNonce() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// renderProtected renders a markdown document to html for the editor, with protected constructs as placeholders.
+func renderProtected(md string) string {
+	nonce := newNonce()
+	md, sources := protectMarkdown(md, nonce)
+	protectedRegex, blockTokenRegex := tokenRegexes(nonce)
+	source := func(match string, regex *regexp.Regexp) (string, bool) {
+		i, err := strconv.Atoi(regex.FindStringSubmatch(match)[1])
+		if err != nil || i >= len(sources) {
+			return "", false
+		}
+		return htmlpkg.EscapeString(sources[i]), true
+	}
+
+	html := blockTokenRegex.ReplaceAllStringFunc(mdToHTML(md), func(match string) string {
+		src, ok := source(match, blockTokenRegex)
+		switch {
+		case !ok:
+			return match
+		case diagramKind(htmlpkg.UnescapeString(src)) != "":
+			return fmt.Sprintf(`<div class="ql-diagram" data-source="%s"></div>`, src)
+		default:
+			return fmt.Sprintf(`<div class="ql-protected" data-source="%s"></div>`, src)
+		}
+	})
+	return protectedRegex.ReplaceAllStringFunc(html, func(match string) string {
+		if src, ok := source(match, protectedRegex); ok {
+			return fmt.Sprintf(`<span class="ql-protected-inline" data-source="%s"></span>`, src)
+		}
+		return match
+	})
+}
+
+// unprotectHTML replaces the placeholders in html submitted by the editor with tokens, returning their source.
+func unprotectHTML(html, nonce string) (string, []string, error) {
+	if !strings.Contains(html, "ql-protected") && !strings.Contains(html, "ql-diagram") {
+		return html, nil, nil
+	}
+
+	nodes, err := htmlnode.ParseFragment(strings.NewReader(html), &htmlnode.Node{Type: htmlnode.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing html: %w", err)
+	}
+
+	var sources []string
+	var visit func(node *htmlnode.Node) *htmlnode.Node
+	visit = func(node *htmlnode.Node) *htmlnode.Node {
+		if node.Type == htmlnode.ElementNode && (hasClass(node, "ql-protected") || hasClass(node, "ql-protected-inline") || hasClass(node, "ql-diagram")) {
+			token := &htmlnode.Node{Type: htmlnode.TextNode, Data: protectedToken(nonce, len(sources))}
+			sources = append(sources, attr(node, "data-source"))
+			if node.Data != "div" {
+				return token
+			}
+			p := &htmlnode.Node{Type: htmlnode.ElementNode, Data: "p", DataAtom: atom.P}
+			p.AppendChild(token)
+			return p
+		}
+
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			if replacement := visit(child); replacement != child {
+				node.InsertBefore(replacement, child)
+				node.RemoveChild(child)
+			}
+			child = next
+		}
+		return node
+	}
+
+	var out strings.Builder
+	for _, node := range nodes {
+		if err := htmlnode.Render(&out, visit(node)); err != nil {
+			return "", nil, fmt.Errorf("rendering html: %w", err)
+		}
+	}
+	return out.String(), sources, nil
+}
+
+// convertBlocks replaces the tables and code blocks in html submitted by the editor with tokens, returning their
+// markdown along with the given sources, since htmltomarkdown can't convert them faithfully. Tokens in the blocks are
+// restored.
+func convertBlocks(html, nonce string, sources []string) (string, []string, error) {
+	if !strings.Contains(html, "<table") && !strings.Contains(html, "<pre") && !strings.Contains(html, "ql-code-block") {
+		return html, sources, nil
+	}
+
+	nodes, err := htmlnode.ParseFragment(strings.NewReader(html), &htmlnode.Node{Type: htmlnode.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing html: %w", err)
+	}
+
+	var visit func(node *htmlnode.Node) (*htmlnode.Node, error)
+	visit = func(node *htmlnode.Node) (*htmlnode.Node, error) {
+		var md string
+		var err error
+		switch {
+		case node.Type != htmlnode.ElementNode:
+		case node.DataAtom == atom.Table:
+			md, err = tableMarkdown(node)
+		case node.DataAtom == atom.Pre || hasClass(node, "ql-code-block-container"):
+			md = codeBlockMarkdown(node)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if md != "" {
+			p := &htmlnode.Node{Type: htmlnode.ElementNode, Data: "p", DataAtom: atom.P}
+			p.AppendChild(&htmlnode.Node{Type: htmlnode.TextNode, Data: protectedToken(nonce, len(sources))})
+			sources = append(sources, restoreProtected(md, nonce, sources))
+			return p, nil
+		}
+
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			replacement, err := visit(child)
+			if err != nil {
+				return nil, err
+			}
+			if replacement != child {
+				node.InsertBefore(replacement, child)
+				node.RemoveChild(child)
+			}
+			child = next
+		}
+		return node, nil
+	}
+
+	var out strings.Builder
+	for _, node := range nodes {
+		node, err := visit(node)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := htmlnode.Render(&out, node); err != nil {
+			return "", nil, fmt.Errorf("rendering html: %w", err)
+		}
+	}
+	return out.String(), sources, nil
+}
+
+// restoreProtected replaces the tokens in markdown with the source of the constructs they stand in for.
+func restoreProtected(md, nonce string, sources []string) string {
+	protectedRegex, _ := tokenRegexes(nonce)
+	return protectedRegex.ReplaceAllStringFunc(md, func(match string) string {
+		i, err := strconv.Atoi(protectedRegex.FindStringSubmatch(match)[1])
+		if err != nil || i >= len(sources) {
+			return match
+		}
+		return sources[i]
+	})
+}
+
+func hasClass(node *htmlnode.Node, class string) bool {
+	return strings.Contains(" "+attr(node, "class")+" ", " "+class+" ")
+}
+
+func attr(node *htmlnode.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
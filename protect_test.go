@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtectMarkdown(t *testing.T) {
+	md := "# Title\n\n" +
+		"{{< figure src=\"a.png\" >}}\n\n" +
+		"{{% notice info %}}\nSome *notice* {{< icon x >}}\n{{% /notice %}}\n\n" +
+		"<div class=\"box\">\n  <b>html</b>\n</div>\n\n" +
+		"Text with <kbd>Ctrl</kbd> and `<code>` and {{< ref \"x\" >}}.\n\n" +
+		"```\n{{< not a shortcode >}}\n<div>\n```\n"
+
+	protected, sources := protectMarkdown(md, "")
+	assert.Equal(t, []string{
+		"{{< figure src=\"a.png\" >}}",
+		"{{% notice info %}}\nSome *notice* {{< icon x >}}\n{{% /notice %}}",
+		"<div class=\"box\">\n  <b>html</b>\n</div>",
+		"<kbd>",
+		"</kbd>",
+		"{{< ref \"x\" >}}",
+	}, sources)
+	assert.Equal(t, "# Title\n\nXPROTECTED0X\n\nXPROTECTED1X\n\nXPROTECTED2X\n\n"+
+		"Text with XPROTECTED3XCtrlXPROTECTED4X and `<code>` and XPROTECTED5X.\n\n"+
+		"```\n{{< not a shortcode >}}\n<div>\n```\n", protected)
+	assert.Equal(t, md, restoreProtected(protected, "", sources))
+}
+
+func TestProtectedRoundTrip(t *testing.T) {
+	html := renderProtected("{{< figure src=\"a.png\" >}}\n\nSee <abbr title=\"x\">this</abbr>\n")
+	assert.Equal(t, "<div class=\"ql-protected\" data-source=\"{{&lt; figure src=&#34;a.png&#34; &gt;}}\"></div>\n\n"+
+		"<p>See <span class=\"ql-protected-inline\" data-source=\"&lt;abbr title=&#34;x&#34;&gt;\"></span>this"+
+		"<span class=\"ql-protected-inline\" data-source=\"&lt;/abbr&gt;\"></span></p>\n", html)
+
+	// The editor wraps inline embeds in its own markup
+	submitted := "<div class=\"ql-protected\" data-source=\"{{&lt; figure src=&quot;a.png&quot; &gt;}}\" contenteditable=\"false\">figure</div>" +
+		"<p>See <span class=\"ql-protected-inline\" data-source=\"&lt;abbr title=&quot;x&quot;&gt;\">\ufeff<span contenteditable=\"false\">abbr</span>\ufeff</span>that</p>"
+	unprotected, sources, err := unprotectHTML(submitted, "")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>XPROTECTED0X</p><p>See XPROTECTED1Xthat</p>", unprotected)
+	assert.Equal(t, []string{"{{< figure src=\"a.png\" >}}", "<abbr title=\"x\">"}, sources)
+
+	// Html without placeholders is left alone
+	unprotected, sources, err = unprotectHTML("<p>plain</p>", "")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>plain</p>", unprotected)
+	assert.Empty(t, sources)
+}
+
+func TestProtectedSave(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	md := "+++\ntitle = \"foo\"\n+++\n# hello\n\n{{< figure src=\"a.png\" caption=\"A  figure\" >}}\n\n" +
+		"{{% notice tip %}}\n**Careful**\n{{% /notice %}}\n\n<table><tr><td>x</td></tr></table>\n\nSome <sup>text</sup> and XPROTECTED1X\n"
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte(md), 0644))
+
+	// Saving the loaded page unchanged keeps everything verbatim, including text that looks like a token
+	html, found, err := readPage("foo/test")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, stageUpdate("foo/test", html, "user@test.com"))
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, md, string(raw))
+
+	// Edits around protected constructs don't touch them
+	edited := "<h1>hello there</h1>" + html[len("<h1 id=\"hello\">hello</h1>"):]
+	require.NoError(t, stageUpdate("foo/test", edited, "user@test.com"))
+
+	raw, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\n+++\n# hello there {#hello}\n\n{{< figure src=\"a.png\" caption=\"A  figure\" >}}\n\n"+
+		"{{% notice tip %}}\n**Careful**\n{{% /notice %}}\n\n<table><tr><td>x</td></tr></table>\n\nSome <sup>text</sup> and XPROTECTED1X\n", string(raw))
+}
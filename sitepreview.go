@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var buildTempl = template.Must(template.New("").Parse(`
+{{- if .build.Running }}
+<meta http-equiv="refresh" content="3" />
+{{- end }}
+<form method="post">
+    <h1>Site preview</h1>
+{{- if .build.Running }}
+    <p>Building {{ .build.Commit | html }}, started {{ .build.Started.Format "2006-01-02 15:04:05 MST" }}...</p>
+{{- else if .build.Finished.IsZero }}
+    <p>The site hasn't been built yet.</p>
+{{- else }}
+    <p>Built {{ .build.Commit | html }} {{ .build.Finished.Format "2006-01-02 15:04:05 MST" }}.</p>
+{{- end }}
+{{- if .build.Err }}
+    <div id="error-banner">The build failed: {{ .build.Err | html }}</div>
+    <pre id="output">{{ .build.Output | html }}</pre>
+{{- end }}
+{{- if .build.Dir }}
+    <p><a href="/preview/" target="_blank">View the site</a>{{ if ne .build.DirCommit .build.Commit }} as of {{ .build.DirCommit | html }}{{ end }}</p>
+{{- end }}
+    <button id="save" type="submit"{{ if .build.Running }} disabled{{ end }}>Build Now</button>
+</form>
+
+<style>
+    body {
+        font-family: sans-serif;
+    }
+
+    #save {
+        border: 1px solid #000;
+        padding: 6px;
+        border-radius: 3px;
+        background: transparent;
+        font-size: 100%;
+        cursor: pointer;
+    }
+
+    #error-banner {
+        padding: 15px;
+        background: #ffd6d6;
+        margin: 15px;
+    }
+
+    #output {
+        padding: 10px;
+        background: #f3f3f3;
+        white-space: pre-wrap;
+        max-height: 400px;
+        overflow-y: auto;
+    }
+</style>
+`))
+
+var (
+	buildCommand []string // the output dir is appended to it (empty to disable the site preview)
+	buildTimeout = 10 * time.Minute
+
+	siteBuildMu sync.Mutex
+	siteBuild   = &siteBuildReport{}
+)
+
+// Only the end of the build's output is kept, which is where errors are
+const maxBuildOutput = 64 << 10
+
+// siteBuildReport is the state of the latest build of the site preview.
+type siteBuildReport struct {
+	Commit    string
+	Started   time.Time
+	Finished  time.Time
+	Running   bool
+	Err       string
+	Output    string
+	Dir       string // output of the last successful build, which is kept when a build fails
+	DirCommit string
+}
+
+// startSiteBuild builds the site in the background unless it's already being built.
+func startSiteBuild() {
+	siteBuildMu.Lock()
+	defer siteBuildMu.Unlock()
+	if siteBuild.Running {
+		return
+	}
+
+	report := *siteBuild
+	report.Running = true
+	report.Started = time.Now()
+	report.Err, report.Output = "", ""
+	siteBuild = &report
+	go runSiteBuild()
+}
+
+// runSiteBuild builds the current commit and stores the result for the preview.
+func runSiteBuild() {
+	commit, dir, output, err := buildSite()
+	if err != nil {
+		slog.Error("error while building the site preview", "error", err)
+	} else {
+		slog.Info("built the site preview", "commit", commit, "latencyMS", time.Since(latestSiteBuild().Started).Milliseconds())
+	}
+
+	siteBuildMu.Lock()
+	defer siteBuildMu.Unlock()
+
+	report := *siteBuild
+	report.Commit = commit
+	report.Finished = time.Now()
+	report.Running = false
+	report.Output = output
+	if err != nil {
+		report.Err = err.Error()
+	} else {
+		if report.Dir != "" {
+			os.RemoveAll(report.Dir)
+		}
+		report.Dir, report.DirCommit = dir, commit
+	}
+	siteBuild = &report
+}
+
+// buildSite runs the build command in a scratch worktree of the current commit. Returns the commit, the output dir
+// and the output of the command.
+func buildSite() (string, string, string, error) {
+	worktree, err := os.MkdirTemp("", "wiki-preview-src-")
+	if err != nil {
+		return "", "", "", fmt.Errorf("creating worktree dir: %w", err)
+	}
+	defer os.RemoveAll(worktree)
+
+	commit, err := addWorktree(worktree)
+	defer removeWorktree(worktree)
+	if err != nil {
+		return commit, "", "", err
+	}
+
+	dir, err := os.MkdirTemp("", "wiki-preview-site-")
+	if err != nil {
+		return commit, "", "", fmt.Errorf("creating output dir: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, buildCommand[0], append(buildCommand[1:], dir)...)
+	cmd.Dir = worktree
+	cmd.WaitDelay = time.Minute // for processes started by the command that outlive it
+	out, err := cmd.CombinedOutput()
+	output := string(out)
+	if len(output) > maxBuildOutput {
+		output = "…" + output[len(output)-maxBuildOutput:]
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %s", buildTimeout)
+	case err != nil:
+		err = fmt.Errorf("running %s: %w", strings.Join(buildCommand, " "), err)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return commit, "", output, err
+	}
+	return commit, dir, output, nil
+}
+
+// addWorktree checks out the current commit (and any submodules e.g. themes) into a new worktree, returning the
+// commit's short hash.
+func addWorktree(dir string) (string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	commit, err := gitOutput("rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("finding current commit: %w", err)
+	}
+	commit = strings.TrimSpace(commit)
+
+	// Forget worktrees that weren't removed e.g. because the server was restarted during a build
+	if err := git("worktree", "prune"); err != nil {
+		return commit, fmt.Errorf("pruning worktrees: %w", err)
+	}
+	if err := git("worktree", "add", "--detach", dir, commit); err != nil {
+		return commit, fmt.Errorf("adding worktree: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err == nil {
+		if err := git("-C", dir, "submodule", "update", "--init", "--recursive"); err != nil {
+			return commit, fmt.Errorf("checking out submodules: %w", err)
+		}
+	}
+	return commit, nil
+}
+
+func removeWorktree(dir string) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	if err := git("worktree", "remove", "--force", dir); err != nil {
+		slog.Warn("unable to remove worktree", "dir", dir, "error", err)
+	}
+}
+
+func latestSiteBuild() *siteBuildReport {
+	siteBuildMu.Lock()
+	defer siteBuildMu.Unlock()
+	return siteBuild
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteBuild(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+	defer func(cmd []string, report *siteBuildReport) { buildCommand, siteBuild = cmd, report }(buildCommand, siteBuild)
+	siteBuild = &siteBuildReport{}
+
+	head, err := gitOutput("rev-parse", "--short", "HEAD")
+	require.NoError(t, err)
+	head = strings.TrimSpace(head)
+
+	// The command builds a worktree of the current commit, uncommitted changes aren't included
+	require.NoError(t, os.WriteFile(filepath.Join("content", "foo", "test.md"), []byte("uncommitted\n"), 0644))
+	buildCommand = []string{"sh", "-c", `cp -r content/. "$0" && echo built`}
+	commit, dir, output, err := buildSite()
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.Equal(t, head, commit)
+	assert.Equal(t, "built\n", output)
+	raw, err := os.ReadFile(filepath.Join(dir, "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\n# hello\n__world__\n", string(raw))
+
+	worktrees, err := gitOutput("worktree", "list")
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(worktrees, "\n"))
+
+	// Failed builds report their output, and keep the previous build around
+	wait := func() *siteBuildReport {
+		startSiteBuild()
+		require.Eventually(t, func() bool { return !latestSiteBuild().Running }, 10*time.Second, 10*time.Millisecond)
+		return latestSiteBuild()
+	}
+	report := wait()
+	require.Empty(t, report.Err)
+	previous := report.Dir
+	assert.DirExists(t, previous)
+	defer os.RemoveAll(previous)
+
+	buildCommand = []string{"sh", "-c", "echo broken >&2; exit 1"}
+	report = wait()
+	assert.Equal(t, "running sh -c echo broken >&2; exit 1: exit status 1", report.Err)
+	assert.Equal(t, "broken\n", report.Output)
+	assert.Equal(t, previous, report.Dir)
+	assert.Equal(t, head, report.DirCommit)
+}
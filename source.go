@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// readPageSource returns the markdown file of a page as is, including its front matter.
+func readPageSource(page string) (string, bool, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	path, found := resolvePage(page)
+	if !found {
+		return "", false, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("reading file: %w", err)
+	}
+	return string(raw), true, nil
+}
+
+// stageSourceUpdate writes markdown edited as source to a page's file (without converting it) and commits it.
+func stageSourceUpdate(page, md, email string) error {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	path, found := resolvePage(page)
+	if !found {
+		return fmt.Errorf("page %q does not exist", page)
+	}
+
+	md, err := sourceUpdate(path, md)
+	if err != nil {
+		return err
+	}
+	_, body := splitFrontmatter(md)
+	return writePage(page, path, md, referencedFiles(mdToHTML(body), path), email)
+}
+
+// reviewSourceUpdate returns warnings about markdown edited as source that the user should confirm before it's saved.
+// Removed content isn't reported, since it can only be removed on purpose.
+func reviewSourceUpdate(page, md string) ([]string, error) {
+	gitLock.Lock()
+	defer gitLock.Unlock()
+
+	path, found := resolvePage(page)
+	if !found {
+		return nil, fmt.Errorf("page %q does not exist", page)
+	}
+
+	md, err := sourceUpdate(path, md)
+	if err != nil {
+		return nil, err
+	}
+	return linkWarnings(path, md)
+}
+
+// sourceUpdate returns the markdown file that should be written for markdown submitted from a textarea, which
+// always uses CRLF line endings, by switching to the line endings of the file stored at path.
+// Markdown with invalid front matter is rejected, since Hugo would fail to build the site.
+// The caller must hold gitLock.
+func sourceUpdate(path, md string) (string, error) {
+	if err := validateFrontmatter(md); err != nil {
+		return "", rejectf("the front matter is invalid: %s", err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading existing file: %w", err)
+	}
+
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	if strings.Contains(string(current), "\r\n") {
+		md = strings.ReplaceAll(md, "\n", "\r\n")
+	}
+	return md, nil
+}
+
+// validateFrontmatter returns an error if a markdown document's front matter isn't valid TOML or YAML.
+func validateFrontmatter(md string) error {
+	if match := yamlFrontmatterRegex.FindStringSubmatch(md); match != nil {
+		// The leading newline makes the line numbers of errors match the file's
+		var values map[string]any
+		if err := yaml.Unmarshal([]byte("\n"+match[1]), &values); err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return nil
+	}
+
+	match := replaceRegex.FindStringSubmatch(md)
+	if match == nil || !strings.HasPrefix(md, match[0]) {
+		for _, delimiter := range []string{"+++", "---"} {
+			if strings.HasPrefix(md, delimiter+"\n") || strings.HasPrefix(md, delimiter+"\r\n") {
+				return fmt.Errorf("it isn't closed by a %s line", delimiter)
+			}
+		}
+		return nil
+	}
+
+	// Errors at the end of the input are reported on the first line unless it ends with a newline
+	var values map[string]any
+	err := toml.Unmarshal([]byte(match[1]+"\n"), &values)
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		row, _ := decodeErr.Position()
+		return fmt.Errorf("line %d: %s", row+1, strings.TrimPrefix(decodeErr.Error(), "toml: "))
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceEditing(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	// The source includes the front matter
+	content, found, err := readPageSource("foo/test")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "+++\ntitle = \"foo\"\nmore = 123\n+++\n# hello\n__world__\n", content)
+
+	_, found, err = readPageSource("foo/bar")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Broken links are reported
+	warnings, err := reviewSourceUpdate("foo/test", "# hello\r\n\r\n[missing](/foo/missing/)\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Broken link to /foo/missing/: page not found"}, warnings)
+
+	// Submitted markdown is written as is, with the line endings of the file
+	md := "+++\r\ntitle = \"foo\"\r\n+++\r\n# hello\r\n__world__ <u>again</u>\r\n\r\n{{< note >}}\r\n"
+	require.NoError(t, stageSourceUpdate("foo/test", md, "user@test.com"))
+	raw, err := os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\n+++\n# hello\n__world__ <u>again</u>\n\n{{< note >}}\n", string(raw))
+
+	message, err := gitOutput("log", "-1", "--format=%B")
+	require.NoError(t, err)
+	assert.Contains(t, message, "Update foo/test\nAuthored by: ")
+
+	require.Error(t, stageSourceUpdate("foo/bar", md, "user@test.com"))
+
+	// Invalid front matter is rejected before it's reviewed or written
+	for _, tc := range []struct{ md, expected string }{
+		{md: "+++\r\ntitle = \"foo\"\r\ndate = \r\n+++\r\nbody\r\n", expected: "the front matter is invalid: line 3: "},
+		{md: "+++\ntitle = foo\n+++\nbody\n", expected: "the front matter is invalid: line 2: "},
+		{md: "+++\ntitle = \"foo\"\nbody\n", expected: "the front matter is invalid: it isn't closed by a +++ line"},
+		{md: "---\ntitle: foo\n  bad: [\n---\nbody\n", expected: "the front matter is invalid: line 3: "},
+		{md: "---\ntitle: foo\nbody\n", expected: "the front matter is invalid: it isn't closed by a --- line"},
+	} {
+		var rejected *userError
+		_, err = reviewSourceUpdate("foo/test", tc.md)
+		require.ErrorAs(t, err, &rejected)
+		assert.Contains(t, rejected.Error(), tc.expected)
+		require.ErrorAs(t, stageSourceUpdate("foo/test", tc.md, "user@test.com"), &rejected)
+	}
+	raw, err = os.ReadFile(filepath.Join("content", "foo", "test.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "+++\ntitle = \"foo\"\n+++\n# hello\n__world__ <u>again</u>\n\n{{< note >}}\n", string(raw))
+	// Valid YAML front matter is accepted
+	require.NoError(t, stageSourceUpdate("foo/test", "---\ntitle: foo\n---\nbody\n", "user@test.com"))
+}
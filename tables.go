@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	htmlnode "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	tableCellRegex  = regexp.MustCompile(`<(?:th|td)(?: align="(\w+)")?>`)
+	cellAlignRegex  = regexp.MustCompile(`(?:^|\s)ql-align-(\w+)`)
+	textAlignRegex  = regexp.MustCompile(`text-align:\s*(\w+)`)
+	cellBreakRegex  = regexp.MustCompile(`\s*\n\s*`)
+	tableAlignments = map[string]string{"": "---", "left": ":---", "center": ":---:", "right": "---:"}
+)
+
+// editorTables prepares the tables rendered by mdToHTML for the editor, which only supports td cells and keeps
+// their alignment as a class.
+func editorTables(html string) string {
+	html = tableCellRegex.ReplaceAllStringFunc(html, func(match string) string {
+		if align := tableCellRegex.FindStringSubmatch(match)[1]; align != "" {
+			return fmt.Sprintf(`<td class="ql-align-%s">`, align)
+		}
+		return "<td>"
+	})
+	return strings.ReplaceAll(html, "</th>", "</td>")
+}
+
+// tableMarkdown converts a table to a GFM pipe table. The first row is the header, and the alignment of its cells
+// is used for their columns.
+func tableMarkdown(table *htmlnode.Node) (string, error) {
+	var rows [][]*htmlnode.Node
+	var collect func(node *htmlnode.Node)
+	collect = func(node *htmlnode.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			switch child.DataAtom {
+			case atom.Tr:
+				var cells []*htmlnode.Node
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						cells = append(cells, cell)
+					}
+				}
+				rows = append(rows, cells)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				collect(child)
+			}
+		}
+	}
+	collect(table)
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	for i, row := range rows {
+		out.WriteString("|")
+		for j := 0; j < columns; j++ {
+			text := ""
+			if j < len(row) {
+				var err error
+				if text, err = cellMarkdown(row[j]); err != nil {
+					return "", err
+				}
+			}
+			out.WriteString(" " + text + " |")
+		}
+		out.WriteString("\n")
+
+		if i == 0 {
+			out.WriteString("|")
+			for j := 0; j < columns; j++ {
+				align := ""
+				if j < len(row) {
+					align = cellAlignment(row[j])
+				}
+				out.WriteString(" " + tableAlignments[align] + " |")
+			}
+			out.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+// cellMarkdown converts the content of a table cell to markdown that fits on one line.
+func cellMarkdown(cell *htmlnode.Node) (string, error) {
+	var inner strings.Builder
+	for child := cell.FirstChild; child != nil; child = child.NextSibling {
+		if err := htmlnode.Render(&inner, child); err != nil {
+			return "", fmt.Errorf("rendering html: %w", err)
+		}
+	}
+
+	md, err := htmltomarkdown.ConvertString(inner.String())
+	if err != nil {
+		return "", err
+	}
+	md = cellBreakRegex.ReplaceAllString(strings.TrimSpace(tidyMarkdown(md)), " ")
+	return strings.ReplaceAll(md, "|", `\|`), nil
+}
+
+func cellAlignment(cell *htmlnode.Node) string {
+	align := attr(cell, "align")
+	if match := cellAlignRegex.FindStringSubmatch(attr(cell, "class")); match != nil {
+		align = match[1]
+	}
+	if match := textAlignRegex.FindStringSubmatch(attr(cell, "style")); match != nil {
+		align = match[1]
+	}
+	if _, ok := tableAlignments[align]; !ok {
+		return ""
+	}
+	return align
+}
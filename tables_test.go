@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditorTables(t *testing.T) {
+	html := editorTables(mdToHTML("| a | b | c |\n|:---|:---:|---|\n| 1 | 2 | 3 |\n"))
+	assert.Equal(t, "<table>\n<thead>\n<tr>\n<td class=\"ql-align-left\">a</td>\n<td class=\"ql-align-center\">b</td>\n<td>c</td>\n</tr>\n</thead>\n\n"+
+		"<tbody>\n<tr>\n<td class=\"ql-align-left\">1</td>\n<td class=\"ql-align-center\">2</td>\n<td>3</td>\n</tr>\n</tbody>\n</table>\n", html)
+}
+
+func TestConvertTables(t *testing.T) {
+	html := `<p>before</p><table><tbody>` +
+		`<tr><td data-row="row-1" class="ql-align-left">Name</td><td data-row="row-1" class="ql-align-right">Count</td><td data-row="row-1">Notes</td></tr>` +
+		`<tr><td data-row="row-2"><strong>a|b</strong></td><td data-row="row-2" class="ql-align-right">2</td><td data-row="row-2">XPROTECTED0X</td></tr>` +
+		`<tr><td data-row="row-3">short</td></tr>` +
+		`</tbody></table>`
+
+	converted, sources, err := convertBlocks(html, "", []string{"{{< icon x >}}"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>before</p><p>XPROTECTED1X</p>", converted)
+	assert.Equal(t, []string{"{{< icon x >}}", "| Name | Count | Notes |\n| :--- | ---: | --- |\n| **a\\|b** | 2 | {{< icon x >}} |\n| short |  |  |"}, sources)
+
+	// Html without tables is left alone
+	converted, sources, err = convertBlocks("<p>plain</p>", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>plain</p>", converted)
+	assert.Empty(t, sources)
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	remote := createTestRepo(t)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, initializeRepo(remote))
+
+	file := filepath.Join("content", "foo", "test.md")
+	require.NoError(t, os.WriteFile(file, []byte("# hello\n\n|a|b|\n|:-:|--:|\n|1|2|\n"), 0644))
+
+	// Unchanged tables keep their formatting
+	html, _, err := readPage("foo/test")
+	require.NoError(t, err)
+	_, lost, err := reviewUpdate("foo/test", html)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	require.NoError(t, stageUpdate("foo/test", html, "user@test.com"))
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n|a|b|\n|:-:|--:|\n|1|2|\n", string(raw))
+
+	// Edited tables are written as pipe tables with the same alignment
+	require.NoError(t, stageUpdate("foo/test", `<h1>hello</h1><table><tbody>`+
+		`<tr><td data-row="1" class="ql-align-center">a</td><td data-row="1" class="ql-align-right">b</td></tr>`+
+		`<tr><td data-row="2">1</td><td data-row="2">3</td></tr>`+
+		`</tbody></table>`, "user@test.com"))
+
+	raw, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# hello\n\n| a | b |\n| :---: | ---: |\n| 1 | 3 |\n", string(raw))
+}
@@ -0,0 +1,91 @@
+package main
+
+import "strings"
+
+// Front matter and site config are decoded with go-toml, which fails on invalid TOML like Hugo does. Updates are
+// written line by line instead, so the formatting and comments of the rest of the document are kept.
+
+// setTOMLValue sets key = value within the given table ("" for the top level) of a TOML document.
+// Every other line is left untouched. Missing top level keys are added before the first table,
+// missing keys of other tables are ignored.
+func setTOMLValue(src, table, key, value string) string {
+	lines := strings.SplitAfter(src, "\n")
+	current := ""
+	firstTable := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if firstTable < 0 {
+				firstTable = i
+			}
+			path, _ := tomlKey(strings.TrimLeft(trimmed, "["), ']')
+			current = strings.Join(path, ".")
+			continue
+		}
+		if current != table {
+			continue
+		}
+
+		path, ok := tomlKey(trimmed, '=')
+		if !ok || len(path) != 1 || path[0] != key {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i] = indent + key + " = " + value + lineEnding(line)
+		return strings.Join(lines, "")
+	}
+
+	if table != "" {
+		return src
+	}
+	newline := key + " = " + value + "\n"
+	if firstTable < 0 {
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") && lines[len(lines)-1] != "" {
+			newline = "\n" + newline
+		}
+		return src + newline
+	}
+	i := firstTable
+	for i > 0 && strings.TrimSpace(lines[i-1]) == "" {
+		i--
+	}
+	lines = append(lines[:i], append([]string{newline}, lines[i:]...)...)
+	return strings.Join(lines, "")
+}
+
+func lineEnding(line string) string {
+	switch {
+	case strings.HasSuffix(line, "\r\n"):
+		return "\r\n"
+	case strings.HasSuffix(line, "\n"):
+		return "\n"
+	default:
+		return ""
+	}
+}
+
+// tomlKey splits a (possibly dotted and/or quoted) key that ends at term. Returns false if term isn't found.
+func tomlKey(s string, term byte) ([]string, bool) {
+	var path []string
+	var part strings.Builder
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			part.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			path = append(path, strings.TrimSpace(part.String()))
+			part.Reset()
+		case c == term:
+			return append(path, strings.TrimSpace(part.String())), true
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTOMLValue(t *testing.T) {
+	src := "title = \"foo\"\nweight = 1\n\n[menu.main]\n  weight = 2\n"
+
+	assert.Equal(t, "title = \"foo\"\nweight = 10\n\n[menu.main]\n  weight = 2\n", setTOMLValue(src, "", "weight", "10"))
+	assert.Equal(t, "title = \"foo\"\nweight = 1\n\n[menu.main]\n  weight = 20\n", setTOMLValue(src, "menu.main", "weight", "20"))
+	assert.Equal(t, "title = \"foo\"\nweight = 1\ndraft = true\n\n[menu.main]\n  weight = 2\n", setTOMLValue(src, "", "draft", "true"))
+	assert.Equal(t, src, setTOMLValue(src, "menu.footer", "weight", "20"))
+	assert.Equal(t, "title = \"foo\"\ndraft = true\n", setTOMLValue("title = \"foo\"", "", "draft", "true"))
+
+	// Quoted and spaced keys are matched, other lines are left alone
+	assert.Equal(t, "[ menu . \"main\" ]\nweight = 20\n", setTOMLValue("[ menu . \"main\" ]\n\"weight\" = 2\n", "menu.main", "weight", "20"))
+	assert.Equal(t, "# weight = 1\nweights = 2\nweight = 3\n", setTOMLValue("# weight = 1\nweights = 2\n", "", "weight", "3"))
+}